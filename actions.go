@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/stephybun/link-milestone/linker"
+)
+
+// writeOutputs appends the given step outputs to the file named by GITHUB_OUTPUT so later workflow steps can read them
+// with ${{ steps.<id>.outputs.<key> }}. Nothing is written when path is empty, i.e. outside of GitHub Actions.
+func writeOutputs(path string, outputs [][2]string) error {
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("opening github output file: %+v", err)
+	}
+	defer f.Close()
+
+	for _, o := range outputs {
+		if _, err := fmt.Fprintf(f, "%s=%s\n", o[0], o[1]); err != nil {
+			return fmt.Errorf("writing github output %s: %+v", o[0], err)
+		}
+	}
+
+	return f.Close()
+}
+
+// prSummary is a pull request's line in the job summary. A nil Result means the pull request was skipped.
+type prSummary struct {
+	PR     linker.GitHubIssue
+	Result *linker.Result
+	Err    error
+}
+
+// writeSummary appends a Markdown report of the pull requests and issues linked to the file named by
+// GITHUB_STEP_SUMMARY, shown on the workflow run's page. Nothing is written when path is empty.
+func writeSummary(path string, mode string, dryRun bool, summaries []prSummary) error {
+	if path == "" {
+		return nil
+	}
+
+	verb := "linked to"
+	if mode == linker.ModeUnlink {
+		verb = "unlinked from"
+	}
+
+	var b strings.Builder
+	b.WriteString("## Milestones\n\n")
+	if dryRun {
+		b.WriteString("> **Dry run:** no milestones were changed.\n\n")
+	}
+	for _, s := range summaries {
+		switch {
+		case s.Err != nil:
+			fmt.Fprintf(&b, "- %s: failed, %s\n", s.PR, s.Err)
+		case s.Result == nil:
+			fmt.Fprintf(&b, "- %s: skipped\n", s.PR)
+		default:
+			fmt.Fprintf(&b, "- %s: %s **%s**\n", s.PR, verb, s.Result.Milestone.GetTitle())
+			// only the issues whose milestone changed, as those already linked or left alone were skipped
+			for _, c := range s.Result.Changes {
+				if c.Issue == s.PR {
+					continue
+				}
+				title := c.To
+				if mode == linker.ModeUnlink {
+					title = c.From
+				}
+				fmt.Fprintf(&b, "  - %s: %s **%s**\n", c.Issue, verb, title)
+			}
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("opening github step summary file: %+v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(b.String()); err != nil {
+		return fmt.Errorf("writing github step summary: %+v", err)
+	}
+	return f.Close()
+}
+
+// writeMetrics writes gauges of what the run did to path in the Prometheus text exposition format, e.g. for the
+// node exporter's textfile collector on a self-hosted runner, which replaces them on every run. runErr is the error
+// the run failed with, counted as an error when no pull request failed, e.g. for a missing token. Nothing is written
+// when path is empty.
+func writeMetrics(path string, summaries []prSummary, runErr error) error {
+	if path == "" {
+		return nil
+	}
+
+	var processed, linked, created, failed int
+	for _, s := range summaries {
+		processed++
+		if s.Err != nil {
+			failed++
+			continue
+		}
+		if s.Result == nil {
+			continue
+		}
+		if s.Result.CreatedMilestone {
+			created++
+		}
+		for _, c := range s.Result.Changes {
+			if c.Issue != s.PR {
+				linked++
+			}
+		}
+	}
+
+	if runErr != nil && failed == 0 {
+		failed = 1
+	}
+
+	var b strings.Builder
+	for _, m := range []struct {
+		name  string
+		help  string
+		value int
+	}{
+		{"link_milestone_prs_processed", "Pull requests processed, including skipped and failed ones.", processed},
+		{"link_milestone_issues_linked", "Issues whose milestone was changed, or would have been in a dry run.", linked},
+		{"link_milestone_milestones_created", "Milestones created because no open version milestone existed.", created},
+		{"link_milestone_errors", "Pull requests that failed to be linked, or 1 when the run failed otherwise.", failed},
+	} {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", m.name, m.help, m.name, m.name, m.value)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("writing metrics file: %+v", err)
+	}
+	return nil
+}
+
+// diffEntry is the milestone change a dry run would make to an issue or pull request. A nil milestone is none.
+type diffEntry struct {
+	Repository        string  `json:"repository"`
+	Number            int     `json:"number"`
+	Title             string  `json:"title"`
+	CurrentMilestone  *string `json:"current_milestone"`
+	ProposedMilestone *string `json:"proposed_milestone"`
+}
+
+// writeDiff writes the changes the pull requests' dry runs would make as a JSON array to w and, when path is set, as
+// the diff step output, so a later step can have them approved before linking for real.
+func writeDiff(w io.Writer, path string, summaries []prSummary) error {
+	entries := []diffEntry{}
+	for _, s := range summaries {
+		if s.Result == nil {
+			continue
+		}
+		for _, c := range s.Result.Changes {
+			entries = append(entries, diffEntry{
+				Repository:        c.Issue.Owner + "/" + c.Issue.Repo,
+				Number:            c.Issue.Id,
+				Title:             c.Title,
+				CurrentMilestone:  optionalString(c.From),
+				ProposedMilestone: optionalString(c.To),
+			})
+		}
+	}
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("encoding dry run diff: %+v", err)
+	}
+	if _, err = fmt.Fprintln(w, string(b)); err != nil {
+		return fmt.Errorf("writing dry run diff: %+v", err)
+	}
+	return writeOutputs(path, [][2]string{{"diff", string(b)}})
+}
+
+// optionalString returns nil for an empty string so it is encoded as null.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// joinIssues formats issues as a comma-separated list of numbers, prefixing those outside of owner/repo with their
+// repository, e.g. "12,other/repo#45".
+func joinIssues(issues []linker.GitHubIssue, owner string, repo string) string {
+	s := make([]string, len(issues))
+	for i, issue := range issues {
+		s[i] = strconv.Itoa(issue.Id)
+		if issue.Owner != owner || issue.Repo != repo {
+			s[i] = fmt.Sprintf("%s/%s#%d", issue.Owner, issue.Repo, issue.Id)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+// eventPullRequest is the pull request of a pull_request event payload, as needed to identify it.
+type eventPullRequest struct {
+	Number int    `json:"number"`
+	Merged bool   `json:"merged"`
+	State  string `json:"state"`
+}
+
+// pullRequestEvent is the part of a pull_request event payload needed to identify the pull request.
+type pullRequestEvent struct {
+	PullRequest *eventPullRequest `json:"pull_request"`
+}
+
+// readPullRequestEvent returns the number, merge state and state of the pull request in the event payload GitHub
+// Actions writes to GITHUB_EVENT_PATH.
+func readPullRequestEvent(path string) (*eventPullRequest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading github event: %+v", err)
+	}
+
+	var event pullRequestEvent
+	if err := json.Unmarshal(b, &event); err != nil {
+		return nil, fmt.Errorf("parsing github event: %+v", err)
+	}
+	if event.PullRequest == nil || event.PullRequest.Number == 0 {
+		return nil, fmt.Errorf("github event %s is not a pull request event", path)
+	}
+
+	return event.PullRequest, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/stephybun/link-milestone/linker"
+)
+
+func TestWriteSummary(t *testing.T) {
+	pr := linker.GitHubIssue{Owner: "owner", Repo: "repo", Id: 10}
+	result := &linker.Result{
+		Milestone:    &github.Milestone{Title: github.String("v1.1.0")},
+		LinkedIssues: []linker.GitHubIssue{{Owner: "owner", Repo: "repo", Id: 11}, {Owner: "owner", Repo: "repo", Id: 12}},
+		Changes: []linker.Change{
+			{Issue: pr, To: "v1.1.0"},
+			{Issue: linker.GitHubIssue{Owner: "owner", Repo: "repo", Id: 11}, To: "v1.1.0"},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "summary.md")
+	if err := writeSummary(path, linker.ModeLink, false, []prSummary{{PR: pr, Result: result}}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary := string(b)
+	if !strings.Contains(summary, "owner/repo#11: linked to **v1.1.0**") {
+		t.Errorf("expected the changed issue to be listed, got:\n%s", summary)
+	}
+	if strings.Contains(summary, "#12") {
+		t.Errorf("expected the unchanged issue to be left out, got:\n%s", summary)
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	result := &linker.Result{
+		Changes: []linker.Change{
+			{Issue: linker.GitHubIssue{Owner: "owner", Repo: "repo", Id: 10}, Title: "Add a feature", To: "v1.1.0"},
+			{Issue: linker.GitHubIssue{Owner: "other", Repo: "project", Id: 34}, Title: "Crash", From: "Backlog", To: "v1.1.0"},
+		},
+	}
+
+	var b strings.Builder
+	path := filepath.Join(t.TempDir(), "output")
+	if err := writeDiff(&b, path, []prSummary{{Result: result}, {}}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := `[{"repository":"owner/repo","number":10,"title":"Add a feature","current_milestone":null,"proposed_milestone":"v1.1.0"},` +
+		`{"repository":"other/project","number":34,"title":"Crash","current_milestone":"Backlog","proposed_milestone":"v1.1.0"}]`
+	if b.String() != expected+"\n" {
+		t.Errorf("expected diff %s, got %s", expected, b.String())
+	}
+	output, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "diff="+expected+"\n" {
+		t.Errorf("expected the diff output to be set, got %s", output)
+	}
+}
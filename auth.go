@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+
+	"github.com/stephybun/link-milestone/linker"
+)
+
+// appCredentials identify a GitHub App installation to authenticate as.
+type appCredentials struct {
+	AppId          int64
+	InstallationId int64
+	// PrivateKey is the app's PEM encoded RSA private key.
+	PrivateKey string
+}
+
+// newTokenSource returns the source of the token used to call the GitHub API. When app credentials are given the
+// client authenticates as that app installation, otherwise token is used as is.
+func newTokenSource(ctx context.Context, token string, app *appCredentials, baseURL string) (oauth2.TokenSource, error) {
+	if app == nil {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), nil
+	}
+
+	key, err := parsePrivateKey(app.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	appClient, err := newGitHubClient(ctx, oauth2.ReuseTokenSource(nil, appTokenSource{app.AppId, key}), baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return oauth2.ReuseTokenSource(nil, installationTokenSource{ctx, appClient, app.InstallationId}), nil
+}
+
+// verifyToken checks the client's credentials with a single cheap call, so that a bad token fails with a clear error
+// rather than deep inside the first real request. Only a 401 means the token was rejected: tokens that may not read
+// the authenticated user, such as the GitHub Actions token, still pass. When GitHub couldn't be asked, e.g. for a
+// network failure or a server error, the token is reported as not verified rather than failing the run.
+func verifyToken(ctx context.Context, client *github.Client) (verified bool, err error) {
+	var resp *github.Response
+	err = linker.WithRetry(ctx, func() (err error) {
+		_, resp, err = client.Users.Get(ctx, "")
+		linker.LogRate(ctx, resp)
+		return err
+	})
+	if err == nil {
+		return true, nil
+	}
+	if resp == nil || resp.Response == nil || resp.StatusCode >= http.StatusInternalServerError {
+		logger.Warnf(linker.LogFields{}, "couldn't verify the token: %+v", err)
+		return false, nil
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return false, fmt.Errorf("authentication failed: github rejected the token, check that it is valid and hasn't expired")
+	}
+	return true, nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS#1 or PKCS#8 RSA private key. Escaped newlines, as found when the key is
+// stored in a single line variable, are accepted.
+func parsePrivateKey(privateKey string) (*rsa.PrivateKey, error) {
+	if !strings.Contains(privateKey, "\n") {
+		privateKey = strings.ReplaceAll(privateKey, `\n`, "\n")
+	}
+
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, fmt.Errorf("parsing github app private key: no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing github app private key: %+v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("parsing github app private key: not an RSA key")
+	}
+	return key, nil
+}
+
+// appTokenSource issues the short-lived JWTs that authenticate as the GitHub App itself.
+type appTokenSource struct {
+	appId int64
+	key   *rsa.PrivateKey
+}
+
+func (s appTokenSource) Token() (*oauth2.Token, error) {
+	// iat is backdated to allow for clock drift, GitHub rejects tokens valid for more than 10 minutes
+	now := time.Now()
+	expiry := now.Add(9 * time.Minute)
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": expiry.Unix(),
+		"iss": s.appId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("signing github app jwt: %+v", err)
+	}
+
+	return &oauth2.Token{AccessToken: unsigned + "." + enc.EncodeToString(signature), Expiry: expiry}, nil
+}
+
+// installationTokenSource exchanges the app's JWT for an installation access token.
+type installationTokenSource struct {
+	ctx            context.Context
+	appClient      *github.Client
+	installationId int64
+}
+
+func (s installationTokenSource) Token() (*oauth2.Token, error) {
+	var token *github.InstallationToken
+	err := linker.WithRetry(s.ctx, func() (err error) {
+		var resp *github.Response
+		token, resp, err = s.appClient.Apps.CreateInstallationToken(s.ctx, s.installationId)
+		linker.LogRate(s.ctx, resp)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating installation token for installation %d: %+v", s.installationId, err)
+	}
+
+	return &oauth2.Token{AccessToken: token.GetToken(), Expiry: token.GetExpiresAt()}, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// The build metadata reported by --version, set at build time with e.g.
+// -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)".
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// errVersion is returned by parseFlags once the version was printed, after which there is nothing left to do.
+var errVersion = errors.New("version requested")
+
+// cliFlags maps each command line flag to the setting it overrides. Settings not given as a flag fall back to their
+// environment variable.
+var cliFlags = []struct {
+	name  string
+	key   string
+	usage string
+}{
+	{"token", "github_token", "token used to authenticate against the GitHub API (env GITHUB_TOKEN)"},
+	{"repo", "github_repository", "repository in owner/repo form (env GITHUB_REPOSITORY)"},
+	{"pr", "pr_number", "number of the merged pull request (env PR_NUMBER)"},
+	{"selection", "milestone_selection", "open version milestone to link to, lowest, highest or closest (env MILESTONE_SELECTION)"},
+}
+
+// parseFlags parses the command line flags and binds them to their settings. It returns pflag.ErrHelp when --help
+// was passed, and errVersion after printing the version for --version or the version subcommand. The check subcommand
+// is the same as MODE=check.
+func parseFlags(args []string) error {
+	flags := pflag.NewFlagSet("link-milestone", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: link-milestone [flags]\n       link-milestone check [flags]\n       link-milestone version\n\n")
+		fmt.Fprintf(os.Stderr, "Links a merged pull request, and the issues it closes, to an open version milestone.\n")
+		fmt.Fprintf(os.Stderr, "Every flag can also be set through the environment variable named in its description.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n%s", flags.FlagUsages())
+	}
+
+	for _, f := range cliFlags {
+		flags.String(f.name, "", f.usage)
+	}
+	flags.Bool("dry-run", false, "log the milestone changes without making them (env DRY_RUN)")
+	showVersion := flags.Bool("version", false, "print the version and exit")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *showVersion || flags.Arg(0) == "version" {
+		fmt.Printf("link-milestone %s (commit %s, built %s)\n", version, commit, date)
+		return errVersion
+	}
+	if flags.Arg(0) == modeCheck {
+		viper.Set("mode", modeCheck)
+	}
+
+	for _, f := range cliFlags {
+		if err := viper.BindPFlag(f.key, flags.Lookup(f.name)); err != nil {
+			return err
+		}
+	}
+	return viper.BindPFlag("dry_run", flags.Lookup("dry-run"))
+}
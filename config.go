@@ -0,0 +1,637 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/stephybun/link-milestone/linker"
+)
+
+// config is the validated configuration of a run.
+type config struct {
+	Token string
+	// App, when set, authenticates as a GitHub App installation instead of with Token.
+	App *appCredentials
+	// BaseURL is the API endpoint of a GitHub Enterprise Server instance, empty for github.com.
+	BaseURL string
+	// Timeout bounds the time spent calling the GitHub API.
+	Timeout time.Duration
+	// ValidateToken checks the credentials before any linking is attempted.
+	ValidateToken bool
+
+	Owner string
+	Repo  string
+	// PrIds are the pull requests to link. It's empty when there is nothing to do, e.g. the pull request in the event
+	// payload was closed without being merged.
+	PrIds []int
+
+	// Mode is linker.ModeLink, linker.ModeUnlink or modeCheck.
+	Mode      string
+	Milestone linker.MilestoneOptions
+	Update    linker.UpdateOptions
+	// SkipPR leaves the pull request's milestone alone and only links its issues.
+	SkipPR bool
+	// LinkOnOpen links open pull requests too, instead of waiting for them to be merged.
+	LinkOnOpen     bool
+	CloseCompleted bool
+	// Concurrency is the maximum number of linked issues updated at once.
+	Concurrency int
+	LinkMode    string
+	Keywords    *regexp.Regexp
+	// KeywordGap is how many words may come between a closing keyword and the issue it references.
+	KeywordGap int
+	// ScanCommits looks for closing keywords in commit messages too.
+	ScanCommits bool
+	// LabelFallback links issues labelled for the milestone when the pull request closes none.
+	LabelFallback bool
+	// TimelineFallback links issues cross-referencing the pull request when no other way finds any.
+	TimelineFallback bool
+	// LinkRepos are the repositories bare issue references are looked up in, in order.
+	LinkRepos []string
+	// ProjectColumn, when set, restricts the linked issues to those with a status in a project.
+	ProjectColumn *linker.ProjectColumn
+	// References are the non-closing phrases whose issues are linked too, nil unless LINK_REFERENCED_ISSUES is set.
+	References [][]string
+	Comment    *template.Template
+	// RequireLabel, when set, is the label pull requests must have to be linked at all.
+	RequireLabel string
+	// ExcludeAuthors are the logins whose pull requests are never linked.
+	ExcludeAuthors []string
+	// FailIfNoMilestone makes finding no milestone to link to a failure.
+	FailIfNoMilestone bool
+
+	// Logger writes the run's log lines in the configured format and level.
+	Logger linker.Logger
+	// MaxRetries, when set, is the number of times a rate limited GitHub API call is retried.
+	MaxRetries *int
+
+	// OutputFormat is outputFormatJSON to print the changes a dry run would make as JSON.
+	OutputFormat string
+	// OutputPath is the GitHub Actions step output file, empty outside of GitHub Actions.
+	OutputPath string
+	// SummaryPath is the GitHub Actions job summary file, empty outside of GitHub Actions.
+	SummaryPath string
+	// MetricsPath, when set, is the file the run's metrics are written to.
+	MetricsPath string
+}
+
+// configFileName is the optional configuration file read from the root of the repository. Its keys are the
+// environment variable names in lower case, e.g. milestone_selection.
+const configFileName = ".link-milestone.yml"
+
+// loadConfig reads the configuration from the environment and any bound command line flags, falling back to the
+// repository's configuration file, and validates it.
+func loadConfig() (*config, error) {
+	viper.AutomaticEnv()
+
+	if err := readConfigFile(); err != nil {
+		return nil, err
+	}
+
+	format := strings.ToLower(viper.GetString("log_format"))
+	if format == "" {
+		format = linker.LogFormatText
+	}
+	if format != linker.LogFormatText && format != linker.LogFormatJSON {
+		return nil, fmt.Errorf("log format must be %q or %q, got %q", linker.LogFormatText, linker.LogFormatJSON, format)
+	}
+
+	outputFormat := strings.ToLower(viper.GetString("output_format"))
+	if outputFormat == "" {
+		outputFormat = outputFormatText
+	}
+	if outputFormat != outputFormatText && outputFormat != outputFormatJSON {
+		return nil, fmt.Errorf("output format must be %q or %q, got %q", outputFormatText, outputFormatJSON, outputFormat)
+	}
+
+	level := strings.ToLower(viper.GetString("log_level"))
+	if level == "" {
+		level = linker.LogLevelInfo
+	}
+	if !linker.ValidLogLevel(level) {
+		return nil, fmt.Errorf("log level must be one of %q, %q, %q or %q, got %q", linker.LogLevelError, linker.LogLevelWarn, linker.LogLevelInfo, linker.LogLevelDebug, level)
+	}
+	logger = linker.Logger{Format: format, Level: level}
+
+	token := viper.GetString("github_token")
+	if path := viper.GetString("github_token_file"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading github token file: %+v", err)
+		}
+		if token = strings.TrimSpace(string(b)); token == "" {
+			return nil, fmt.Errorf("github token file %s is empty", path)
+		}
+	}
+	owner, repo, err := resolveRepository(viper.GetString("github_repository"), viper.GetString("github_owner"), viper.GetString("github_repo"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range strings.Split(viper.GetString("skip_repos"), ",") {
+		if strings.EqualFold(strings.TrimSpace(r), owner+"/"+repo) {
+			logger.Infof(linker.LogFields{}, "%s/%s is listed in SKIP_REPOS, skipping", owner, repo)
+			return &config{Owner: owner, Repo: repo}, nil
+		}
+	}
+
+	// checking the setup doesn't involve a pull request, and unlinking is for pull requests that were reverted or
+	// reopened, so whether they were merged doesn't matter
+	check := strings.EqualFold(viper.GetString("mode"), modeCheck)
+	unlink := strings.EqualFold(viper.GetString("mode"), linker.ModeUnlink)
+
+	prIds, err := parsePullRequestNumbers(viper.GetString("pr_number"), viper.GetString("pr_numbers"))
+	if err != nil {
+		return nil, err
+	}
+	if len(prIds) == 0 && !check {
+		eventPath := viper.GetString("github_event_path")
+		if eventPath == "" {
+			return nil, fmt.Errorf("parsing pr number: one of PR_NUMBER, PR_NUMBERS or GITHUB_EVENT_PATH must be set")
+		}
+
+		pr, err := readPullRequestEvent(eventPath)
+		if err != nil {
+			return nil, err
+		}
+		open := strings.EqualFold(pr.State, "open")
+		if !pr.Merged && !unlink && !(open && viper.GetBool("link_on_open")) {
+			fields := linker.LogFields{Issue: linker.GitHubIssue{Owner: owner, Repo: repo, Id: pr.Number}.String()}
+			if open {
+				logger.Infof(fields, "pull request #%d is open and LINK_ON_OPEN isn't set, skipping", pr.Number)
+			} else {
+				logger.Infof(fields, "pull request #%d was closed without being merged, skipping", pr.Number)
+			}
+			return &config{Owner: owner, Repo: repo}, nil
+		}
+		prIds = []int{pr.Number}
+	}
+
+	selection := strings.ToLower(viper.GetString("milestone_selection"))
+	if selection == "" {
+		selection = linker.SelectionLowest
+	}
+	if selection != linker.SelectionLowest && selection != linker.SelectionHighest && selection != linker.SelectionClosest {
+		return nil, fmt.Errorf("milestone selection must be %q, %q or %q, got %q", linker.SelectionLowest, linker.SelectionHighest, linker.SelectionClosest, selection)
+	}
+	reference := strings.TrimSpace(viper.GetString("reference_version"))
+	if selection == linker.SelectionClosest && reference == "" {
+		return nil, fmt.Errorf("REFERENCE_VERSION must be set for the %q milestone selection", linker.SelectionClosest)
+	}
+
+	bump := strings.ToLower(viper.GetString("milestone_bump"))
+	if bump == "" {
+		bump = linker.BumpPatch
+	}
+	if bump != linker.BumpPatch && bump != linker.BumpMinor && bump != linker.BumpMajor {
+		return nil, fmt.Errorf("milestone bump must be %q, %q or %q, got %q", linker.BumpPatch, linker.BumpMinor, linker.BumpMajor, bump)
+	}
+
+	var maxRetries *int
+	if viper.IsSet("max_retries") {
+		n := viper.GetInt("max_retries")
+		if n < 0 {
+			return nil, fmt.Errorf("max retries must not be negative, got %d", n)
+		}
+		maxRetries = &n
+	}
+
+	concurrency := 4
+	if viper.IsSet("concurrency") {
+		concurrency = viper.GetInt("concurrency")
+		if concurrency < 1 {
+			return nil, fmt.Errorf("concurrency must be at least 1, got %d", concurrency)
+		}
+	}
+
+	prerelease := strings.ToLower(viper.GetString("prerelease"))
+	if prerelease == "" {
+		prerelease = prereleaseInclude
+	}
+	if prerelease != prereleaseInclude && prerelease != prereleaseIgnore {
+		return nil, fmt.Errorf("prerelease must be %q or %q, got %q", prereleaseInclude, prereleaseIgnore, prerelease)
+	}
+
+	var scheme linker.VersionScheme
+	switch s := strings.ToLower(viper.GetString("version_scheme")); s {
+	case "", linker.SchemeSemver:
+		scheme = linker.SemverScheme{}
+	case linker.SchemeCalver:
+		scheme = linker.CalverScheme{}
+	default:
+		return nil, fmt.Errorf("version scheme must be %q or %q, got %q", linker.SchemeSemver, linker.SchemeCalver, s)
+	}
+	// an organisation wide default pattern is overridden by the repository's config file, which is overridden by the
+	// environment of the run
+	viper.SetDefault("milestone_pattern", viper.GetString("org_milestone_pattern"))
+	if pattern := viper.GetString("milestone_pattern"); pattern != "" {
+		if scheme, err = linker.NewPatternScheme(pattern); err != nil {
+			return nil, err
+		}
+		logger.Debugf(linker.LogFields{}, "using milestone pattern %q from %s", pattern, milestonePatternSource())
+	}
+
+	reference = linker.NormalizeTitle(scheme, reference)
+
+	var excludeAuthors []string
+	for _, a := range strings.Split(viper.GetString("exclude_authors"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			excludeAuthors = append(excludeAuthors, a)
+		}
+	}
+
+	var linkRepos []string
+	for _, r := range strings.Split(viper.GetString("link_repos"), ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		if _, _, err := parseRepository(r); err != nil {
+			return nil, fmt.Errorf("parsing link repos: %+v", err)
+		}
+		linkRepos = append(linkRepos, r)
+	}
+
+	var projectColumn *linker.ProjectColumn
+	if filter := strings.TrimSpace(viper.GetString("project_column_filter")); filter != "" {
+		i := strings.LastIndex(filter, "/")
+		if i <= 0 || i == len(filter)-1 {
+			return nil, fmt.Errorf("project column filter must be given as project/status, got %q", filter)
+		}
+		projectColumn = &linker.ProjectColumn{Project: strings.TrimSpace(filter[:i]), Column: strings.TrimSpace(filter[i+1:])}
+	}
+
+	var backportScheme linker.VersionScheme
+	backportLabel := viper.GetString("backport_label")
+	if pattern := viper.GetString("backport_milestone_pattern"); pattern != "" {
+		if backportScheme, err = linker.NewPatternScheme(pattern); err != nil {
+			return nil, err
+		}
+		if backportLabel == "" {
+			backportLabel = "backport"
+		}
+	}
+
+	var exclude []string
+	for _, p := range strings.Split(viper.GetString("milestone_exclude"), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("parsing milestone exclude pattern %q: %+v", p, err)
+		}
+		exclude = append(exclude, p)
+	}
+
+	milestoneNumber := 0
+	if n := viper.GetString("milestone_number"); n != "" {
+		if milestoneNumber, err = strconv.Atoi(n); err != nil || milestoneNumber <= 0 {
+			return nil, fmt.Errorf("milestone number must be a positive number, got %q", n)
+		}
+	}
+	milestoneTitle := viper.GetString("milestone_title")
+	if milestoneNumber != 0 && milestoneTitle != "" {
+		return nil, fmt.Errorf("only one of MILESTONE_NUMBER and MILESTONE_TITLE may be set")
+	}
+
+	var branchPattern *regexp.Regexp
+	branchTitle := viper.GetString("branch_milestone_title")
+	if p := viper.GetString("branch_milestone_pattern"); p != "" {
+		if branchPattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("compiling branch milestone pattern %q: %+v", p, err)
+		}
+		if branchTitle == "" {
+			branchTitle = "$0"
+			if branchPattern.NumSubexp() > 0 {
+				branchTitle = "${1}"
+			}
+		}
+	}
+
+	var titleVersionPattern *regexp.Regexp
+	if p := viper.GetString("title_version_pattern"); p != "" {
+		if titleVersionPattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("compiling title version pattern %q: %+v", p, err)
+		}
+	}
+
+	version, err := readVersionFile(viper.GetString("version_file"))
+	if err != nil {
+		return nil, err
+	}
+
+	dueInDays := 0
+	if d := viper.GetString("milestone_due_in_days"); d != "" {
+		if dueInDays, err = strconv.Atoi(d); err != nil || dueInDays <= 0 {
+			return nil, fmt.Errorf("milestone due in days must be a positive number, got %q", d)
+		}
+	}
+
+	maxOpen := viper.GetInt("max_open_milestones")
+	if maxOpen < 0 {
+		return nil, fmt.Errorf("max open milestones must not be negative, got %d", maxOpen)
+	}
+
+	minMilestone := viper.GetString("min_milestone")
+	if minMilestone != "" {
+		minMilestone = linker.NormalizeTitle(scheme, minMilestone)
+		if !scheme.Match(minMilestone) {
+			return nil, fmt.Errorf("min milestone %q is not a version milestone title", viper.GetString("min_milestone"))
+		}
+	}
+
+	mode := strings.ToLower(viper.GetString("mode"))
+	if mode == "" {
+		mode = linker.ModeLink
+	}
+	if mode != linker.ModeLink && mode != linker.ModeUnlink && mode != modeCheck {
+		return nil, fmt.Errorf("mode must be %q, %q or %q, got %q", linker.ModeLink, linker.ModeUnlink, modeCheck, mode)
+	}
+
+	labelMilestones, err := parseLabelMilestones(viper.GetString("label_to_milestone"))
+	if err != nil {
+		return nil, err
+	}
+
+	linkMode := strings.ToLower(viper.GetString("link_mode"))
+	if linkMode == "" {
+		linkMode = linker.LinkModeRegex
+	}
+	if linkMode != linker.LinkModeRegex && linkMode != linker.LinkModeGraphQL {
+		return nil, fmt.Errorf("link mode must be %q or %q, got %q", linker.LinkModeRegex, linker.LinkModeGraphQL, linkMode)
+	}
+
+	keywords, err := linker.NewKeywordRegexp(viper.GetString("closing_keywords"))
+	if err != nil {
+		return nil, err
+	}
+	keywordGap := viper.GetInt("keyword_gap")
+	if keywordGap < 0 {
+		return nil, fmt.Errorf("keyword gap must not be negative, got %d", keywordGap)
+	}
+
+	var references [][]string
+	if viper.GetBool("link_referenced_issues") {
+		references = linker.NewReferencePhrases(viper.GetString("reference_keywords"))
+	}
+
+	var comment *template.Template
+	if viper.GetBool("add_comment") {
+		text := viper.GetString("comment_template")
+		if text == "" {
+			text = linker.DefaultCommentTemplate
+		}
+		if comment, err = template.New("comment").Parse(text); err != nil {
+			return nil, fmt.Errorf("parsing comment template: %+v", err)
+		}
+	}
+
+	var app *appCredentials
+	if viper.GetString("github_app_id") != "" || viper.GetString("github_installation_id") != "" || viper.GetString("github_private_key") != "" {
+		app = &appCredentials{PrivateKey: viper.GetString("github_private_key")}
+		if app.AppId, err = strconv.ParseInt(viper.GetString("github_app_id"), 10, 64); err != nil {
+			return nil, fmt.Errorf("parsing github app id: %+v", err)
+		}
+		if app.InstallationId, err = strconv.ParseInt(viper.GetString("github_installation_id"), 10, 64); err != nil {
+			return nil, fmt.Errorf("parsing github installation id: %+v", err)
+		}
+		if app.PrivateKey == "" {
+			return nil, fmt.Errorf("github private key must be set to authenticate as a github app")
+		}
+	}
+
+	if token == "" && app == nil {
+		return nil, fmt.Errorf("github token must be set, either as GITHUB_TOKEN or through a github app")
+	}
+
+	baseURL := viper.GetString("github_base_url")
+	if baseURL == "" {
+		baseURL = viper.GetString("github_api_url")
+	}
+
+	timeout := 30 * time.Second
+	if viper.IsSet("request_timeout") {
+		if timeout = viper.GetDuration("request_timeout"); timeout <= 0 {
+			return nil, fmt.Errorf("request timeout must be a positive duration, got %q", viper.GetString("request_timeout"))
+		}
+	}
+
+	return &config{
+		Token:   token,
+		App:     app,
+		BaseURL: baseURL,
+		Timeout: timeout,
+
+		ValidateToken: viper.GetBool("validate_token"),
+
+		Owner: owner,
+		Repo:  repo,
+		PrIds: prIds,
+
+		Mode: mode,
+		Milestone: linker.MilestoneOptions{
+			Selection: selection,
+			Reference: reference,
+			Create:    viper.GetBool("create_milestone"),
+			Bump:      bump,
+			DueInDays: dueInDays,
+
+			IncludePrerelease:   prerelease == prereleaseInclude,
+			Scheme:              scheme,
+			Exclude:             exclude,
+			MaxOpen:             maxOpen,
+			Min:                 minMilestone,
+			Number:              milestoneNumber,
+			Title:               milestoneTitle,
+			Version:             version,
+			TitleVersionPattern: titleVersionPattern,
+			BackportScheme:      backportScheme,
+			BackportLabel:       backportLabel,
+			BranchPattern:       branchPattern,
+			BranchTitle:         branchTitle,
+			LabelMilestones:     labelMilestones,
+		},
+		Update: linker.UpdateOptions{
+			DryRun:              viper.GetBool("dry_run"),
+			ForceReassign:       viper.GetBool("force_reassign"),
+			IncludeNotPlanned:   viper.GetBool("include_not_planned"),
+			IncludePullRequests: viper.GetBool("link_prs"),
+			Verify:              viper.GetBool("verify"),
+		},
+		SkipPR:            viper.GetBool("skip_pr"),
+		LinkOnOpen:        viper.GetBool("link_on_open"),
+		CloseCompleted:    viper.GetBool("close_completed_milestone"),
+		Concurrency:       concurrency,
+		LinkMode:          linkMode,
+		Keywords:          keywords,
+		KeywordGap:        keywordGap,
+		ScanCommits:       viper.GetBool("scan_commits"),
+		LabelFallback:     viper.GetBool("label_fallback"),
+		TimelineFallback:  viper.GetBool("timeline_fallback"),
+		LinkRepos:         linkRepos,
+		ProjectColumn:     projectColumn,
+		References:        references,
+		Comment:           comment,
+		RequireLabel:      strings.TrimSpace(viper.GetString("require_label")),
+		ExcludeAuthors:    excludeAuthors,
+		FailIfNoMilestone: viper.GetBool("fail_if_no_milestone"),
+
+		Logger:     logger,
+		MaxRetries: maxRetries,
+
+		OutputFormat: outputFormat,
+		OutputPath:   viper.GetString("github_output"),
+		SummaryPath:  viper.GetString("github_step_summary"),
+		MetricsPath:  viper.GetString("metrics_file"),
+	}, nil
+}
+
+// resolveRepository returns the owner and name of the repository given as owner/repo in repository or, when that is
+// empty, given separately as owner and repo, as some CI systems other than GitHub Actions do.
+func resolveRepository(repository string, owner string, repo string) (string, string, error) {
+	if repository != "" {
+		return parseRepository(repository)
+	}
+	owner, repo = strings.TrimSpace(owner), strings.TrimSpace(repo)
+	if owner == "" || repo == "" {
+		return "", "", fmt.Errorf("github repository must be set as GITHUB_REPOSITORY or both GITHUB_OWNER and GITHUB_REPO, got owner %q and repo %q", owner, repo)
+	}
+	return owner, repo, nil
+}
+
+// parseRepository splits a repository in owner/repo form into its owner and name.
+func parseRepository(repository string) (string, string, error) {
+	parts := strings.Split(repository, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("github repository must be in owner/repo form, got %q", repository)
+	}
+	return parts[0], parts[1], nil
+}
+
+// readConfigFile reads the configuration file named by CONFIG_FILE, or else .link-milestone.yml in the checked out
+// repository, if there is one. Environment variables and flags take precedence over its settings.
+func readConfigFile() error {
+	path := viper.GetString("config_file")
+	if path == "" {
+		dir := viper.GetString("github_workspace")
+		if dir == "" {
+			dir = "."
+		}
+		path = filepath.Join(dir, configFileName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+	}
+
+	viper.SetConfigFile(path)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("reading config file %s: %+v", path, err)
+	}
+	// the selection may also be named after its command line flag, which only moves the file's value over once read
+	viper.RegisterAlias("selection", "milestone_selection")
+	logger.Debugf(linker.LogFields{}, "read configuration from %s", path)
+	return nil
+}
+
+// milestonePatternSource describes where the milestone pattern in use was set.
+func milestonePatternSource() string {
+	switch {
+	case os.Getenv("MILESTONE_PATTERN") != "":
+		return "MILESTONE_PATTERN"
+	case viper.InConfig("milestone_pattern"):
+		return viper.ConfigFileUsed()
+	default:
+		return "ORG_MILESTONE_PATTERN"
+	}
+}
+
+// versionPattern finds a semantic version, with or without its "v" prefix, in a version file.
+var versionPattern = regexp.MustCompile(`\bv?\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?\b`)
+
+// readVersionFile returns the first semantic version found in the file at path, relative to the workspace, or an empty
+// string when no path is given or the file doesn't exist, e.g. on branches that have yet to add it.
+func readVersionFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	if dir := viper.GetString("github_workspace"); dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		logger.Debugf(linker.LogFields{}, "version file %s doesn't exist, using the selected version milestone", path)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading version file %s: %+v", path, err)
+	}
+
+	version := versionPattern.FindString(string(content))
+	if version == "" {
+		return "", fmt.Errorf("version file %s doesn't contain a semantic version", path)
+	}
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	logger.Debugf(linker.LogFields{}, "read version %s from %s", version, path)
+	return version, nil
+}
+
+// parseLabelMilestones parses a mapping of pull request labels to milestone titles, given either as a JSON object or
+// as comma-separated label=milestone pairs, e.g. "backport/1.2=v1.2.x,backport/1.3=v1.3.x".
+func parseLabelMilestones(mapping string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if mapping = strings.TrimSpace(mapping); mapping == "" {
+		return labels, nil
+	}
+
+	if strings.HasPrefix(mapping, "{") {
+		if err := json.Unmarshal([]byte(mapping), &labels); err != nil {
+			return nil, fmt.Errorf("parsing label to milestone mapping: %+v", err)
+		}
+		return labels, nil
+	}
+
+	for _, pair := range strings.Split(mapping, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("parsing label to milestone mapping: %q must be in label=milestone form", pair)
+		}
+		labels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return labels, nil
+}
+
+// parsePullRequestNumbers returns the pull request numbers given as a single number and/or a comma-separated list,
+// without duplicates.
+func parsePullRequestNumbers(number string, list string) ([]int, error) {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, n := range append([]string{number}, strings.Split(list, ",")...) {
+		if n = strings.TrimSpace(n); n == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(n)
+		if err != nil {
+			return nil, fmt.Errorf("parsing pr number: %+v", err)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
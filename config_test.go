@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseRepository(t *testing.T) {
+	cases := []struct {
+		repository string
+		owner      string
+		repo       string
+		err        bool
+	}{
+		{repository: "owner/repo", owner: "owner", repo: "repo"},
+		{repository: "", err: true},
+		{repository: "noslash", err: true},
+		{repository: "owner/repo/extra", err: true},
+		{repository: "/repo", err: true},
+		{repository: "owner/", err: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.repository, func(t *testing.T) {
+			owner, repo, err := parseRepository(tc.repository)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %s/%s", owner, repo)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if owner != tc.owner || repo != tc.repo {
+				t.Errorf("expected %s/%s, got %s/%s", tc.owner, tc.repo, owner, repo)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRepository(t *testing.T) {
+	cases := []struct {
+		name       string
+		repository string
+		owner      string
+		repo       string
+		expected   string
+		err        bool
+	}{
+		{name: "combined", repository: "owner/repo", expected: "owner/repo"},
+		{name: "split", owner: "owner", repo: "repo", expected: "owner/repo"},
+		{name: "combined over split", repository: "owner/repo", owner: "other", repo: "project", expected: "owner/repo"},
+		{name: "combined over partial split", repository: "owner/repo", owner: "other", expected: "owner/repo"},
+		{name: "invalid combined over split", repository: "owner", owner: "owner", repo: "repo", err: true},
+		{name: "owner only", owner: "owner", err: true},
+		{name: "repo only", repo: "repo", err: true},
+		{name: "neither", err: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetConfig(t)
+			setenv(t, "GITHUB_TOKEN", "token")
+			setenv(t, "PR_NUMBER", "10")
+			setenv(t, "GITHUB_REPOSITORY", tc.repository)
+			setenv(t, "GITHUB_OWNER", tc.owner)
+			setenv(t, "GITHUB_REPO", tc.repo)
+
+			cfg, err := loadConfig()
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %s/%s", cfg.Owner, cfg.Repo)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if repository := cfg.Owner + "/" + cfg.Repo; repository != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, repository)
+			}
+		})
+	}
+}
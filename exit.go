@@ -0,0 +1,36 @@
+package main
+
+// Exit codes, so callers can tell expected outcomes from failures that need attention. Finding no milestone to link
+// to, or issues that are already linked, is not a failure and exits with exitOK, unless FAIL_IF_NO_MILESTONE is set.
+const (
+	exitOK = 0
+	// exitFailure is used for any failure not covered by a more specific code.
+	exitFailure = 1
+	// exitConfig means the configuration is missing or invalid.
+	exitConfig = 2
+	// exitGitHub means a GitHub API call failed, e.g. because of bad credentials or an outage.
+	exitGitHub = 3
+	// exitNoMilestone means no milestone could be found where one was required, with FAIL_IF_NO_MILESTONE.
+	exitNoMilestone = 4
+)
+
+// exitError is returned by run to make the process exit with code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// exitCode returns the code the process exits with for err.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if e, ok := err.(*exitError); ok {
+		return e.code
+	}
+	return exitFailure
+}
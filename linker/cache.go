@@ -0,0 +1,135 @@
+package linker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+// milestoneCache is an issuesService that remembers the milestones listed for each repository for the rest of the
+// run, so linking several pull requests or issues in the same repository lists its milestones only once. Creating or
+// editing a milestone in a repository forgets what was listed for it.
+type milestoneCache struct {
+	issuesService
+
+	mu sync.Mutex
+	// pages holds the listed pages by owner/repo, then by the list options that fetched them.
+	pages map[string]map[string]milestonePage
+}
+
+type milestonePage struct {
+	milestones []*github.Milestone
+	resp       *github.Response
+}
+
+var _ issuesService = (*milestoneCache)(nil)
+
+func newMilestoneCache(issues issuesService) *milestoneCache {
+	return &milestoneCache{
+		issuesService: issues,
+		pages:         make(map[string]map[string]milestonePage),
+	}
+}
+
+func (c *milestoneCache) ListMilestones(ctx context.Context, owner string, repo string, opt *github.MilestoneListOptions) ([]*github.Milestone, *github.Response, error) {
+	key := "default"
+	if opt != nil {
+		key = fmt.Sprintf("%s/%s/%s/%d/%d", opt.State, opt.Sort, opt.Direction, opt.Page, opt.PerPage)
+	}
+
+	c.mu.Lock()
+	page, ok := c.pages[owner+"/"+repo][key]
+	c.mu.Unlock()
+	if ok {
+		return page.milestones, page.resp, nil
+	}
+
+	milestones, resp, err := c.issuesService.ListMilestones(ctx, owner, repo, opt)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pages[owner+"/"+repo] == nil {
+		c.pages[owner+"/"+repo] = make(map[string]milestonePage)
+	}
+	c.pages[owner+"/"+repo][key] = milestonePage{milestones, resp}
+	return milestones, resp, nil
+}
+
+func (c *milestoneCache) CreateMilestone(ctx context.Context, owner string, repo string, milestone *github.Milestone) (*github.Milestone, *github.Response, error) {
+	defer c.forget(owner, repo)
+	return c.issuesService.CreateMilestone(ctx, owner, repo, milestone)
+}
+
+func (c *milestoneCache) EditMilestone(ctx context.Context, owner string, repo string, number int, milestone *github.Milestone) (*github.Milestone, *github.Response, error) {
+	defer c.forget(owner, repo)
+	return c.issuesService.EditMilestone(ctx, owner, repo, number, milestone)
+}
+
+// forget drops the milestones listed for owner/repo.
+func (c *milestoneCache) forget(owner string, repo string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pages, owner+"/"+repo)
+}
+
+// issueCache is an issuesService that remembers each issue it gets for the rest of the run, so an issue looked at more
+// than once, e.g. a pull request whose description and labels are both read, is only fetched once. Editing an issue or
+// removing its milestone forgets it, so it is fetched again the next time it is read.
+type issueCache struct {
+	issuesService
+
+	mu     sync.Mutex
+	issues map[string]*fullIssue
+}
+
+var _ issuesService = (*issueCache)(nil)
+
+func newIssueCache(issues issuesService) *issueCache {
+	return &issueCache{
+		issuesService: issues,
+		issues:        make(map[string]*fullIssue),
+	}
+}
+
+func (c *issueCache) Get(ctx context.Context, owner string, repo string, number int) (*fullIssue, *github.Response, error) {
+	key := GitHubIssue{owner, repo, number}.String()
+
+	c.mu.Lock()
+	issue, ok := c.issues[key]
+	c.mu.Unlock()
+	if ok {
+		return issue, nil, nil
+	}
+
+	issue, resp, err := c.issuesService.Get(ctx, owner, repo, number)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.issues[key] = issue
+	return issue, resp, nil
+}
+
+func (c *issueCache) Edit(ctx context.Context, owner string, repo string, number int, issue *github.IssueRequest) (*github.Issue, *github.Response, error) {
+	defer c.forget(owner, repo, number)
+	return c.issuesService.Edit(ctx, owner, repo, number, issue)
+}
+
+func (c *issueCache) RemoveMilestone(ctx context.Context, owner string, repo string, number int) (*github.Issue, *github.Response, error) {
+	defer c.forget(owner, repo, number)
+	return c.issuesService.RemoveMilestone(ctx, owner, repo, number)
+}
+
+// forget drops the issue owner/repo#number.
+func (c *issueCache) forget(owner string, repo string, number int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.issues, GitHubIssue{owner, repo, number}.String())
+}
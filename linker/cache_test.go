@@ -0,0 +1,64 @@
+package linker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMilestoneCacheListsOnce(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	cache := newMilestoneCache(issues)
+
+	opts := MilestoneOptions{Selection: SelectionLowest, Scheme: SemverScheme{}}
+	for _, n := range []int{12, 13, 14} {
+		milestone, _, err := GitHubIssue{"owner", "repo", n}.getMilestone(context.Background(), cache, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		if milestone.GetTitle() != "v1.0.0" {
+			t.Errorf("expected milestone v1.0.0, got %s", milestone.GetTitle())
+		}
+	}
+	if issues.calls["ListMilestones"] != 1 {
+		t.Errorf("expected the milestones to be listed once, got %d", issues.calls["ListMilestones"])
+	}
+
+	// creating a milestone lists them again
+	if _, _, err := cache.CreateMilestone(context.Background(), "owner", "repo", issues.milestone("owner", "repo", 2)); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if _, _, err := testPR.getMilestone(context.Background(), cache, opts); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if issues.calls["ListMilestones"] != 2 {
+		t.Errorf("expected the milestones to be listed again after creating one, got %d", issues.calls["ListMilestones"])
+	}
+}
+
+func TestIssueCacheGetsOnce(t *testing.T) {
+	issues := newFakeIssues()
+	milestone := issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issue := GitHubIssue{"owner", "repo", 12}
+	issues.addIssue(issue, "closed", "")
+	cache := newIssueCache(issues)
+
+	// the description is read and the issue updated, fetching it a second time
+	if _, err := issue.getDescription(context.Background(), cache); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if _, err := issue.updateMilestone(context.Background(), cache, milestone, UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if issues.calls["Get"] != 1 {
+		t.Errorf("expected the issue to be fetched once, got %d", issues.calls["Get"])
+	}
+
+	// editing the issue fetches it again
+	if _, err := issue.getDescription(context.Background(), cache); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if issues.calls["Get"] != 2 {
+		t.Errorf("expected the issue to be fetched again after editing it, got %d", issues.calls["Get"])
+	}
+}
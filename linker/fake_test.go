@@ -0,0 +1,310 @@
+package linker
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// fakeIssues is an in-memory issuesService for tests. Milestones are kept by owner/repo and numbered by the test.
+type fakeIssues struct {
+	mu sync.Mutex
+
+	milestones   map[string][]*github.Milestone
+	issues       map[GitHubIssue]*github.Issue
+	stateReasons map[GitHubIssue]string
+	timelines    map[GitHubIssue][]*timelineEvent
+	// byRepo are the issues returned by ListByRepo, filtered by label.
+	byRepo map[string][]*github.Issue
+
+	// pageSize, when set, splits listed milestones into pages of that many.
+	pageSize int
+	// errs fails the calls to the method it is keyed by, e.g. "Edit", or only those for one repository or issue, e.g.
+	// "Edit owner/repo#12" or "ListMilestones owner/repo".
+	errs map[string]error
+	// dropEdits answers edits without changing the issue, as GitHub occasionally does.
+	dropEdits bool
+	// emptyEdits answers edits with no issue, as for a response with an empty body.
+	emptyEdits bool
+
+	// calls counts the calls to each method, and to each method for each target as keyed in errs.
+	calls map[string]int
+	// listOpts are the options of each ListMilestones call, as GitHub would have received them.
+	listOpts []github.MilestoneListOptions
+	edits    []fakeEdit
+	created  []*github.Milestone
+	comments []string
+}
+
+// fakeEdit is an issue's milestone being set, or removed when Milestone is zero.
+type fakeEdit struct {
+	Issue     GitHubIssue
+	Milestone int
+}
+
+var _ issuesService = (*fakeIssues)(nil)
+
+func newFakeIssues() *fakeIssues {
+	return &fakeIssues{
+		milestones:   make(map[string][]*github.Milestone),
+		issues:       make(map[GitHubIssue]*github.Issue),
+		stateReasons: make(map[GitHubIssue]string),
+		timelines:    make(map[GitHubIssue][]*timelineEvent),
+		byRepo:       make(map[string][]*github.Issue),
+		errs:         make(map[string]error),
+		calls:        make(map[string]int),
+	}
+}
+
+// statusError is the error go-github returns for a response with the given status code.
+func statusError(code int) *github.ErrorResponse {
+	req := &http.Request{Method: "GET", URL: &url.URL{Path: "/fake"}}
+	return &github.ErrorResponse{Response: &http.Response{StatusCode: code, Request: req}, Message: http.StatusText(code)}
+}
+
+// captureLog returns the text log lines written until the end of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &b
+}
+
+// call counts a call to method for target and returns the error it should fail with, if any.
+func (f *fakeIssues) call(method string, target string) (*github.Response, error) {
+	f.calls[method]++
+	f.calls[method+" "+target]++
+	err, ok := f.errs[method+" "+target]
+	if !ok {
+		err, ok = f.errs[method]
+	}
+	if !ok {
+		return &github.Response{}, nil
+	}
+	if e, ok := err.(*github.ErrorResponse); ok {
+		return &github.Response{Response: e.Response}, err
+	}
+	return nil, err
+}
+
+func (f *fakeIssues) addMilestone(owner string, repo string, number int, title string, state string) *github.Milestone {
+	m := &github.Milestone{Number: github.Int(number), Title: github.String(title), State: github.String(state)}
+	f.milestones[owner+"/"+repo] = append(f.milestones[owner+"/"+repo], m)
+	return m
+}
+
+func (f *fakeIssues) addIssue(issue GitHubIssue, state string, body string) *github.Issue {
+	i := &github.Issue{
+		Number: github.Int(issue.Id),
+		State:  github.String(state),
+		Title:  github.String("issue " + issue.String()),
+		Body:   github.String(body),
+	}
+	f.issues[issue] = i
+	return i
+}
+
+func (f *fakeIssues) milestone(owner string, repo string, number int) *github.Milestone {
+	for _, m := range f.milestones[owner+"/"+repo] {
+		if m.GetNumber() == number {
+			return m
+		}
+	}
+	return nil
+}
+
+func (f *fakeIssues) ListMilestones(ctx context.Context, owner string, repo string, opt *github.MilestoneListOptions) ([]*github.Milestone, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listOpts = append(f.listOpts, *opt)
+	resp, err := f.call("ListMilestones", owner+"/"+repo)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	var listed []*github.Milestone
+	for _, m := range f.milestones[owner+"/"+repo] {
+		if opt.State == "all" || opt.State == m.GetState() || (opt.State == "" && m.GetState() == "open") {
+			c := *m
+			listed = append(listed, &c)
+		}
+	}
+
+	if f.pageSize > 0 {
+		page := opt.Page
+		if page == 0 {
+			page = 1
+		}
+		start := (page - 1) * f.pageSize
+		if start > len(listed) {
+			start = len(listed)
+		}
+		end := start + f.pageSize
+		if end < len(listed) {
+			resp.NextPage = page + 1
+		} else {
+			end = len(listed)
+		}
+		listed = listed[start:end]
+	}
+	return listed, resp, nil
+}
+
+func (f *fakeIssues) CreateMilestone(ctx context.Context, owner string, repo string, milestone *github.Milestone) (*github.Milestone, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp, err := f.call("CreateMilestone", owner+"/"+repo)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	number := 1
+	for _, m := range f.milestones[owner+"/"+repo] {
+		if m.GetNumber() >= number {
+			number = m.GetNumber() + 1
+		}
+	}
+	m := f.addMilestone(owner, repo, number, milestone.GetTitle(), "open")
+	m.DueOn = milestone.DueOn
+	f.created = append(f.created, m)
+	c := *m
+	return &c, resp, nil
+}
+
+func (f *fakeIssues) Get(ctx context.Context, owner string, repo string, number int) (*fullIssue, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp, err := f.call("Get", GitHubIssue{owner, repo, number}.String())
+	if err != nil {
+		return nil, resp, err
+	}
+
+	issue, ok := f.issues[GitHubIssue{owner, repo, number}]
+	if !ok {
+		e := statusError(http.StatusNotFound)
+		return nil, &github.Response{Response: e.Response}, e
+	}
+	c := *issue
+	return &fullIssue{Issue: &c, StateReason: f.stateReasons[GitHubIssue{owner, repo, number}]}, resp, nil
+}
+
+func (f *fakeIssues) Edit(ctx context.Context, owner string, repo string, number int, req *github.IssueRequest) (*github.Issue, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp, err := f.call("Edit", GitHubIssue{owner, repo, number}.String())
+	if err != nil {
+		return nil, resp, err
+	}
+
+	key := GitHubIssue{owner, repo, number}
+	f.edits = append(f.edits, fakeEdit{key, req.GetMilestone()})
+	issue := f.issues[key]
+	if !f.dropEdits {
+		issue.Milestone = f.milestone(owner, repo, req.GetMilestone())
+	}
+	if f.emptyEdits {
+		return nil, resp, nil
+	}
+	c := *issue
+	return &c, resp, nil
+}
+
+func (f *fakeIssues) RemoveMilestone(ctx context.Context, owner string, repo string, number int) (*github.Issue, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp, err := f.call("RemoveMilestone", GitHubIssue{owner, repo, number}.String())
+	if err != nil {
+		return nil, resp, err
+	}
+
+	key := GitHubIssue{owner, repo, number}
+	f.edits = append(f.edits, fakeEdit{key, 0})
+	issue := f.issues[key]
+	issue.Milestone = nil
+	c := *issue
+	return &c, resp, nil
+}
+
+func (f *fakeIssues) GetMilestone(ctx context.Context, owner string, repo string, number int) (*github.Milestone, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp, err := f.call("GetMilestone", owner+"/"+repo)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	m := f.milestone(owner, repo, number)
+	if m == nil {
+		e := statusError(http.StatusNotFound)
+		return nil, &github.Response{Response: e.Response}, e
+	}
+	c := *m
+	return &c, resp, nil
+}
+
+func (f *fakeIssues) EditMilestone(ctx context.Context, owner string, repo string, number int, milestone *github.Milestone) (*github.Milestone, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp, err := f.call("EditMilestone", owner+"/"+repo)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	m := f.milestone(owner, repo, number)
+	if milestone.State != nil {
+		m.State = milestone.State
+	}
+	c := *m
+	return &c, resp, nil
+}
+
+func (f *fakeIssues) CreateComment(ctx context.Context, owner string, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp, err := f.call("CreateComment", GitHubIssue{owner, repo, number}.String())
+	if err != nil {
+		return nil, resp, err
+	}
+
+	f.comments = append(f.comments, comment.GetBody())
+	return comment, resp, nil
+}
+
+func (f *fakeIssues) ListByRepo(ctx context.Context, owner string, repo string, opt *github.IssueListByRepoOptions) ([]*github.Issue, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp, err := f.call("ListByRepo", owner+"/"+repo)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	var listed []*github.Issue
+	for _, issue := range f.byRepo[owner+"/"+repo] {
+		for _, label := range issue.Labels {
+			if len(opt.Labels) > 0 && label.GetName() == opt.Labels[0] {
+				listed = append(listed, issue)
+				break
+			}
+		}
+	}
+	sort.Slice(listed, func(i, j int) bool { return listed[i].GetNumber() < listed[j].GetNumber() })
+	return listed, resp, nil
+}
+
+func (f *fakeIssues) ListIssueTimeline(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*timelineEvent, *github.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp, err := f.call("ListIssueTimeline", GitHubIssue{owner, repo, number}.String())
+	if err != nil {
+		return nil, resp, err
+	}
+	return f.timelines[GitHubIssue{owner, repo, number}], resp, nil
+}
@@ -0,0 +1,120 @@
+package linker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const closingIssuesQuery = `query($owner: String!, $repo: String!, $number: Int!) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      closingIssuesReferences(first: 100) {
+        nodes {
+          number
+          repository {
+            name
+            owner {
+              login
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+type graphqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type closingIssuesResponse struct {
+	Data struct {
+		Repository struct {
+			PullRequest struct {
+				ClosingIssuesReferences struct {
+					Nodes []struct {
+						Number     int `json:"number"`
+						Repository struct {
+							Name  string `json:"name"`
+							Owner struct {
+								Login string `json:"login"`
+							} `json:"owner"`
+						} `json:"repository"`
+					} `json:"nodes"`
+				} `json:"closingIssuesReferences"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// getClosingIssues returns the issues GitHub will close when the pull request is merged, as reported by the GraphQL
+// API. Unlike parsing the description this includes issues linked from the sidebar or by commit messages.
+func (g GitHubIssue) getClosingIssues(ctx context.Context, client *github.Client) ([]GitHubIssue, error) {
+	query := graphqlRequest{
+		Query: closingIssuesQuery,
+		Variables: map[string]interface{}{
+			"owner":  g.Owner,
+			"repo":   g.Repo,
+			"number": g.Id,
+		},
+	}
+
+	var resp closingIssuesResponse
+	if err := queryGraphQL(ctx, client, query, &resp); err != nil {
+		return nil, fmt.Errorf("querying closing issues for #%d: %+v", g.Id, err)
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("querying closing issues for #%d: %s", g.Id, resp.Errors[0].Message)
+	}
+
+	var linked []GitHubIssue
+	for _, n := range resp.Data.Repository.PullRequest.ClosingIssuesReferences.Nodes {
+		linked = append(linked, GitHubIssue{n.Repository.Owner.Login, n.Repository.Name, n.Number})
+	}
+
+	if len(linked) == 0 {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "no closing issue references found for pull request")
+	}
+	return linked, nil
+}
+
+// graphqlURL returns the GraphQL endpoint belonging to the client's REST API, which on GitHub Enterprise Server lives
+// at /api/graphql rather than under /api/v3.
+func graphqlURL(client *github.Client) string {
+	if client.BaseURL != nil && strings.HasSuffix(client.BaseURL.Path, "/api/v3/") {
+		u := *client.BaseURL
+		u.Path = strings.TrimSuffix(u.Path, "v3/") + "graphql"
+		return u.String()
+	}
+	return "graphql"
+}
+
+// webHost returns the host the client's issues are found on in a browser, github.com or the GitHub Enterprise Server
+// the client calls.
+func webHost(client *github.Client) string {
+	if graphqlURL(client) == "graphql" {
+		return "github.com"
+	}
+	return client.BaseURL.Host
+}
+
+// queryGraphQL sends query to the GraphQL API and decodes the response into v, which holds the data and any errors.
+func queryGraphQL(ctx context.Context, client *github.Client, query graphqlRequest, v interface{}) error {
+	return WithRetry(ctx, func() error {
+		// the request body is consumed when sent, so it's rebuilt on every attempt
+		req, err := client.NewRequest("POST", graphqlURL(client), query)
+		if err != nil {
+			return err
+		}
+		res, err := client.Do(ctx, req, v)
+		LogRate(ctx, res)
+		return err
+	})
+}
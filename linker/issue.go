@@ -0,0 +1,1119 @@
+package linker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	SelectionLowest  = "lowest"
+	SelectionHighest = "highest"
+	SelectionClosest = "closest"
+
+	BumpPatch = "patch"
+	BumpMinor = "minor"
+	BumpMajor = "major"
+
+	ModeLink   = "link"
+	ModeUnlink = "unlink"
+
+	LinkModeRegex   = "regex"
+	LinkModeGraphQL = "graphql"
+)
+
+// DefaultKeywords matches the words that close an issue when followed by a reference to it, the same set GitHub
+// recognises: close, closes, closed, fix, fixes, fixed, resolve, resolves and resolved, in any case.
+var DefaultKeywords = regexp.MustCompile(`^(?i:close[sd]?|fix(es|ed)?|resolve[sd]?)$`)
+
+// issuesService is the subset of the GitHub issues API used to link milestones, satisfied by github.IssuesService.
+type issuesService interface {
+	ListMilestones(ctx context.Context, owner string, repo string, opt *github.MilestoneListOptions) ([]*github.Milestone, *github.Response, error)
+	CreateMilestone(ctx context.Context, owner string, repo string, milestone *github.Milestone) (*github.Milestone, *github.Response, error)
+	Get(ctx context.Context, owner string, repo string, number int) (*fullIssue, *github.Response, error)
+	Edit(ctx context.Context, owner string, repo string, number int, issue *github.IssueRequest) (*github.Issue, *github.Response, error)
+	GetMilestone(ctx context.Context, owner string, repo string, number int) (*github.Milestone, *github.Response, error)
+	EditMilestone(ctx context.Context, owner string, repo string, number int, milestone *github.Milestone) (*github.Milestone, *github.Response, error)
+	CreateComment(ctx context.Context, owner string, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error)
+	RemoveMilestone(ctx context.Context, owner string, repo string, number int) (*github.Issue, *github.Response, error)
+	ListByRepo(ctx context.Context, owner string, repo string, opt *github.IssueListByRepoOptions) ([]*github.Issue, *github.Response, error)
+	ListIssueTimeline(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*timelineEvent, *github.Response, error)
+}
+
+var _ issuesService = issuesClient{}
+
+// issuesClient is the issuesService backed by the GitHub API. It adds the calls github.IssuesService lacks: removing
+// an issue's milestone, which github.IssueRequest can't express, getting issues along with why they were closed and
+// listing timeline events along with the issues they come from.
+type issuesClient struct {
+	*github.IssuesService
+	client *github.Client
+}
+
+// RemoveMilestone clears the milestone of an issue.
+func (c issuesClient) RemoveMilestone(ctx context.Context, owner string, repo string, number int) (*github.Issue, *github.Response, error) {
+	u := fmt.Sprintf("repos/%v/%v/issues/%d", owner, repo, number)
+	req, err := c.client.NewRequest("PATCH", u, map[string]interface{}{"milestone": nil})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	issue := new(github.Issue)
+	resp, err := c.client.Do(ctx, req, issue)
+	if err != nil {
+		return nil, resp, err
+	}
+	return issue, resp, nil
+}
+
+// fullIssue is a github.Issue along with why it was closed, e.g. "completed" or "not_planned", which go-github doesn't
+// decode. StateReason is empty when the issue is open or GitHub doesn't record a reason.
+type fullIssue struct {
+	*github.Issue
+	StateReason string `json:"state_reason"`
+}
+
+// Get fetches an issue along with why it was closed.
+func (c issuesClient) Get(ctx context.Context, owner string, repo string, number int) (*fullIssue, *github.Response, error) {
+	u := fmt.Sprintf("repos/%v/%v/issues/%d", owner, repo, number)
+	req, err := c.client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	issue := new(fullIssue)
+	resp, err := c.client.Do(ctx, req, issue)
+	if err != nil {
+		return nil, resp, err
+	}
+	return issue, resp, nil
+}
+
+type GitHubIssue struct {
+	Owner string
+	Repo  string
+	Id    int
+}
+
+// String returns the issue as owner/repo#number.
+func (g GitHubIssue) String() string {
+	return fmt.Sprintf("%s/%s#%d", g.Owner, g.Repo, g.Id)
+}
+
+// MilestoneOptions controls how getMilestoneId picks the milestone to link to.
+type MilestoneOptions struct {
+	// Selection is SelectionLowest, SelectionHighest or SelectionClosest.
+	Selection string
+	// Reference is the version SelectionClosest selects the closest milestone to, e.g. the current release.
+	Reference string
+	// Create enables creating the next version milestone when no open one exists.
+	Create bool
+	// Bump is the part of the version incremented when creating a milestone, one of BumpPatch, BumpMinor or BumpMajor.
+	Bump string
+	// DueInDays, when above zero, sets the due date of a created milestone to that many days from now.
+	DueInDays int
+	// IncludePrerelease allows prerelease versions such as v1.2.0-rc1 to be selected.
+	IncludePrerelease bool
+	// Scheme recognises and orders the version milestones.
+	Scheme VersionScheme
+	// Exclude lists titles, or glob patterns as understood by path.Match, of milestones that are never selected.
+	Exclude []string
+	// MaxOpen, when above zero, is the most open version milestones there may be before selecting one is refused.
+	MaxOpen int
+	// Min, when set, is the lowest version considered, so stale milestones below it are never picked nor created.
+	Min string
+	// BranchPattern, when set, matches the release branches whose pull requests are linked to the milestone titled
+	// BranchTitle, expanded with the pattern's capture groups as by regexp.Expand, e.g. release/(\d+\.\d+) and v$1.x.
+	BranchPattern *regexp.Regexp
+	BranchTitle   string
+	// Number or Title, when set, name the milestone to link to, bypassing every other way of selecting one.
+	Number int
+	Title  string
+	// BackportScheme, when set, recognises and orders the maintenance milestones, e.g. v1.2.x, that backport pull
+	// requests are linked to instead of the selected version milestone. A pull request is a backport when it is
+	// labelled BackportLabel or its title starts with "backport", e.g. "[Backport 1.2] Fix ...".
+	BackportScheme VersionScheme
+	BackportLabel  string
+	// TitleVersionPattern, when set, finds the version a pull request releases in its title, e.g. ^Release (v\S+)$,
+	// from its first capture group or the whole match, which selects the open milestone as Version does.
+	TitleVersionPattern *regexp.Regexp
+	// Version, when set, selects the open milestone with that version as its title, e.g. the one being released,
+	// instead of the lowest or highest version. The selection is used when no such milestone is open.
+	Version string
+	// LabelMilestones maps pull request labels to the title of the milestone to link to instead of the selected
+	// version milestone.
+	LabelMilestones map[string]string
+}
+
+// getMilestone returns the open version milestone picked by opts.Selection, which is either the lowest or the highest
+// version. When only one open version milestone exists both selections return it, when there are none nil is returned
+// unless opts.Create is set, in which case the milestone is created and reported as such.
+func (g GitHubIssue) getMilestone(ctx context.Context, issues issuesService, opts MilestoneOptions) (*github.Milestone, bool, error) {
+	ghMilestones, err := g.listMilestones(ctx, issues, "open")
+	if err != nil {
+		return nil, false, err
+	}
+
+	milestones := make(map[string]*github.Milestone)
+	var unmatched []string
+
+	for _, m := range ghMilestones {
+		if m.Title == nil || m.State == nil || m.Number == nil {
+			loggerFrom(ctx).Debugf(LogFields{}, "skipping milestone with missing title, state or number: %+v", m)
+			continue
+		}
+
+		if pattern, ok := excluded(opts.Exclude, strings.TrimSpace(*m.Title)); ok {
+			loggerFrom(ctx).Debugf(LogFields{Milestone: *m.Title}, "skipping milestone %s excluded by %q", *m.Title, pattern)
+			continue
+		}
+
+		title := NormalizeTitle(opts.Scheme, *m.Title)
+		if strings.EqualFold(*m.State, "closed") {
+			continue
+		}
+		if !opts.Scheme.Match(title) {
+			unmatched = append(unmatched, *m.Title)
+			continue
+		}
+		if !opts.IncludePrerelease && semver.Prerelease(title) != "" {
+			loggerFrom(ctx).Debugf(LogFields{Milestone: title}, "skipping prerelease milestone %s", title)
+			continue
+		}
+		if opts.Min != "" && opts.Scheme.Compare(title, opts.Min) < 0 {
+			loggerFrom(ctx).Debugf(LogFields{Milestone: title}, "skipping milestone %s below the minimum %s", title, opts.Min)
+			continue
+		}
+
+		// keep the first due, then oldest, of the milestones for the same version so the choice doesn't depend on
+		// listing order
+		if dup, ok := milestones[title]; ok {
+			kept := dup
+			if earlier(m, dup) {
+				kept = m
+			}
+			loggerFrom(ctx).Warnf(LogFields{Milestone: title}, "open milestones %q (%d) and %q (%d) are both version %s, using %q (%d)", dup.GetTitle(), dup.GetNumber(), m.GetTitle(), m.GetNumber(), title, kept.GetTitle(), kept.GetNumber())
+			milestones[title] = kept
+			continue
+		}
+		milestones[title] = m
+	}
+
+	if opts.MaxOpen > 0 && len(milestones) > opts.MaxOpen {
+		return nil, false, fmt.Errorf("%s/%s has %d open version milestones, more than the maximum of %d: close the milestones that were already released", g.Owner, g.Repo, len(milestones), opts.MaxOpen)
+	}
+
+	if len(milestones) == 0 {
+		switch {
+		case len(ghMilestones) == 0:
+			loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "%s/%s has no open milestones", g.Owner, g.Repo)
+		case len(unmatched) > 0:
+			sample := unmatched
+			if len(sample) > 5 {
+				sample = sample[:5]
+			}
+			loggerFrom(ctx).Warnf(LogFields{Issue: g.String()}, "none of the %d open milestones in %s/%s are version milestones, skipped %d that don't match the version scheme, e.g. %q", len(ghMilestones), g.Owner, g.Repo, len(unmatched), sample)
+		}
+
+		if opts.Create {
+			milestone, err := g.createNextMilestone(ctx, issues, opts)
+			return milestone, err == nil, err
+		}
+		return nil, false, nil
+	}
+
+	var versions []string
+	for title, _ := range milestones {
+		versions = append(versions, title)
+	}
+	version := selectVersion(opts.Scheme, opts.Selection, opts.Reference, versions, milestones)
+
+	loggerFrom(ctx).Debugf(LogFields{Milestone: version}, "%s open version milestone: %s", opts.Selection, version)
+	return milestones[version], false, nil
+}
+
+// selectVersion returns the lowest, highest or closest to reference of the milestone titles versions, depending on
+// selection. Titles of the same version, e.g. under a pattern that only captures part of the title, are told apart by
+// earlier. Of two versions as close to reference, the one at or above it is selected, as the release to come.
+func selectVersion(scheme VersionScheme, selection string, reference string, versions []string, milestones map[string]*github.Milestone) string {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if c := scheme.Compare(versions[i], versions[j]); c != 0 {
+			return c < 0
+		}
+		return earlier(milestones[versions[i]], milestones[versions[j]])
+	})
+
+	if selection == SelectionClosest {
+		closest := versions[0]
+		for _, v := range versions[1:] {
+			if closer(scheme, reference, v, closest) || (!closer(scheme, reference, closest, v) && scheme.Compare(closest, reference) < 0 && scheme.Compare(v, reference) >= 0) {
+				closest = v
+			}
+		}
+		return closest
+	}
+	if selection != SelectionHighest {
+		return versions[0]
+	}
+	// the first of the titles tied for the highest version
+	i := len(versions) - 1
+	for i > 0 && scheme.Compare(versions[i-1], versions[i]) == 0 {
+		i--
+	}
+	return versions[i]
+}
+
+// earlier reports whether milestone a is due before b, where milestones without a due date come last, or has the lower
+// number when they are due at the same time.
+func earlier(a *github.Milestone, b *github.Milestone) bool {
+	switch {
+	case a.DueOn != nil && b.DueOn == nil:
+		return true
+	case a.DueOn == nil && b.DueOn != nil:
+		return false
+	case a.DueOn != nil && !a.DueOn.Equal(*b.DueOn):
+		return a.DueOn.Before(*b.DueOn)
+	}
+	return a.GetNumber() < b.GetNumber()
+}
+
+// excluded returns the first of patterns matching title.
+func excluded(patterns []string, title string) (string, bool) {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, title); ok || p == title {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// NormalizeTitle returns the milestone title with surrounding whitespace trimmed and, for titles that only match the
+// scheme with one, a "v" prefix added, so that e.g. "1.0.0 " and "v1.0.0" are the same version.
+func NormalizeTitle(scheme VersionScheme, title string) string {
+	title = strings.TrimSpace(title)
+	if !scheme.Match(title) && scheme.Match("v"+title) {
+		return "v" + title
+	}
+	return title
+}
+
+// getLabelMilestone returns the open milestone mapped to the first of the issue's labels found in labelMilestones, or
+// nil when none of its labels are mapped.
+func (g GitHubIssue) getLabelMilestone(ctx context.Context, issues issuesService, labelMilestones map[string]string) (*github.Milestone, error) {
+	issue, _, err := g.get(ctx, issues)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, label := range issue.Labels {
+		title, ok := labelMilestones[label.GetName()]
+		if !ok {
+			continue
+		}
+
+		milestone, err := g.findMilestone(ctx, issues, title)
+		if err != nil {
+			return nil, err
+		}
+		if milestone == nil {
+			loggerFrom(ctx).Debugf(LogFields{Issue: g.String(), Milestone: title}, "label %s is mapped to milestone %s which isn't open, ignoring", label.GetName(), title)
+			continue
+		}
+
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String(), Milestone: title}, "label %s selects milestone %s", label.GetName(), title)
+		return milestone, nil
+	}
+
+	return nil, nil
+}
+
+// listMilestones returns every milestone in the repository with the given state, following pagination.
+func (g GitHubIssue) listMilestones(ctx context.Context, issues issuesService, state string) ([]*github.Milestone, error) {
+	var ghMilestones []*github.Milestone
+	// GitHub filters by state so e.g. closed milestones aren't fetched at all, and sorts by due date so the pages come in
+	// a stable order, as many milestones to a page as allowed
+	opts := &github.MilestoneListOptions{
+		State:       state,
+		Sort:        "due_on",
+		Direction:   "asc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		var page []*github.Milestone
+		var resp *github.Response
+		err := WithRetry(ctx, func() (err error) {
+			page, resp, err = issues.ListMilestones(ctx, g.Owner, g.Repo, opts)
+			LogRate(ctx, resp)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("retrieving list of milestones: %+v", err)
+		}
+		ghMilestones = append(ghMilestones, page...)
+
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return ghMilestones, nil
+}
+
+// getNamedMilestone returns the milestone named by opts.Number or opts.Title, failing when it doesn't exist or is
+// closed.
+func (g GitHubIssue) getNamedMilestone(ctx context.Context, issues issuesService, opts MilestoneOptions) (*github.Milestone, error) {
+	var milestone *github.Milestone
+	if opts.Number != 0 {
+		var resp *github.Response
+		err := WithRetry(ctx, func() (err error) {
+			milestone, resp, err = issues.GetMilestone(ctx, g.Owner, g.Repo, opts.Number)
+			LogRate(ctx, resp)
+			return err
+		})
+		if resp != nil && resp.Response != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("milestone number %d doesn't exist in %s/%s", opts.Number, g.Owner, g.Repo)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("getting milestone number %d: %+v", opts.Number, err)
+		}
+	} else {
+		ghMilestones, err := g.listMilestones(ctx, issues, "all")
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range ghMilestones {
+			if strings.TrimSpace(m.GetTitle()) == strings.TrimSpace(opts.Title) {
+				milestone = m
+				break
+			}
+		}
+		if milestone == nil {
+			return nil, fmt.Errorf("milestone %s doesn't exist in %s/%s", opts.Title, g.Owner, g.Repo)
+		}
+	}
+
+	if strings.EqualFold(milestone.GetState(), "closed") {
+		return nil, fmt.Errorf("milestone %s (%d) is closed", milestone.GetTitle(), milestone.GetNumber())
+	}
+
+	loggerFrom(ctx).Debugf(LogFields{Issue: g.String(), Milestone: milestone.GetTitle()}, "using configured milestone %s (%d)", milestone.GetTitle(), milestone.GetNumber())
+	return milestone, nil
+}
+
+// getBranchMilestone returns the open milestone for the base branch of the pull request when it matches
+// opts.BranchPattern, or nil when it doesn't match, e.g. for main, or the milestone isn't open.
+func (g GitHubIssue) getBranchMilestone(ctx context.Context, issues issuesService, branch string, opts MilestoneOptions) (*github.Milestone, error) {
+	match := opts.BranchPattern.FindStringSubmatchIndex(branch)
+	if match == nil {
+		return nil, nil
+	}
+	title := string(opts.BranchPattern.ExpandString(nil, opts.BranchTitle, branch, match))
+
+	milestone, err := g.findMilestone(ctx, issues, title)
+	if err != nil {
+		return nil, err
+	}
+	if milestone == nil {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String(), Milestone: title}, "branch %s is mapped to milestone %s which isn't open, ignoring", branch, title)
+		return nil, nil
+	}
+
+	loggerFrom(ctx).Debugf(LogFields{Issue: g.String(), Milestone: title}, "branch %s selects milestone %s", branch, title)
+	return milestone, nil
+}
+
+// getBackportMilestone returns the open maintenance milestone matching opts.BackportScheme picked by opts.Selection, or
+// nil when there is none.
+func (g GitHubIssue) getBackportMilestone(ctx context.Context, issues issuesService, opts MilestoneOptions) (*github.Milestone, error) {
+	ghMilestones, err := g.listMilestones(ctx, issues, "open")
+	if err != nil {
+		return nil, err
+	}
+
+	milestones := make(map[string]*github.Milestone)
+	var versions []string
+	for _, m := range ghMilestones {
+		title := strings.TrimSpace(m.GetTitle())
+		if _, ok := excluded(opts.Exclude, title); ok || !opts.BackportScheme.Match(title) {
+			continue
+		}
+		if dup, ok := milestones[title]; !ok {
+			versions = append(versions, title)
+		} else if !earlier(m, dup) {
+			continue
+		}
+		milestones[title] = m
+	}
+	if len(versions) == 0 {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "%s/%s has no open maintenance milestones, using the selected version milestone for the backport", g.Owner, g.Repo)
+		return nil, nil
+	}
+	version := selectVersion(opts.BackportScheme, opts.Selection, opts.Reference, versions, milestones)
+
+	loggerFrom(ctx).Debugf(LogFields{Issue: g.String(), Milestone: version}, "%s open maintenance milestone for the backport: %s", opts.Selection, version)
+	return milestones[version], nil
+}
+
+// getVersionMilestone returns the open milestone whose title is opts.Version, with or without its "v" prefix, or nil
+// when there is none.
+func (g GitHubIssue) getVersionMilestone(ctx context.Context, issues issuesService, opts MilestoneOptions) (*github.Milestone, error) {
+	ghMilestones, err := g.listMilestones(ctx, issues, "open")
+	if err != nil {
+		return nil, err
+	}
+
+	version := NormalizeTitle(opts.Scheme, opts.Version)
+	for _, m := range ghMilestones {
+		if NormalizeTitle(opts.Scheme, m.GetTitle()) == version {
+			loggerFrom(ctx).Debugf(LogFields{Issue: g.String(), Milestone: m.GetTitle()}, "version %s selects milestone %s", opts.Version, m.GetTitle())
+			return m, nil
+		}
+	}
+
+	loggerFrom(ctx).Warnf(LogFields{Issue: g.String()}, "no open milestone is titled %s, selecting the %s version milestone instead", opts.Version, opts.Selection)
+	return nil, nil
+}
+
+// titleVersion returns the version found by pattern in the title of a pull request, from its first capture group or
+// the whole match, or an empty string when it doesn't match.
+func titleVersion(pattern *regexp.Regexp, title string) string {
+	match := pattern.FindStringSubmatch(title)
+	if match == nil {
+		return ""
+	}
+	if len(match) > 1 {
+		return strings.TrimSpace(match[1])
+	}
+	return strings.TrimSpace(match[0])
+}
+
+// findMilestone returns the open milestone titled title, or nil when the repository has none.
+func (g GitHubIssue) findMilestone(ctx context.Context, issues issuesService, title string) (*github.Milestone, error) {
+	ghMilestones, err := g.listMilestones(ctx, issues, "open")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range ghMilestones {
+		if strings.TrimSpace(m.GetTitle()) == strings.TrimSpace(title) {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
+
+// closeMilestoneIfCompleted closes the milestone when it has no open issues left. The milestone is fetched again so
+// the count includes the issues that were just linked.
+func (g GitHubIssue) closeMilestoneIfCompleted(ctx context.Context, issues issuesService, milestone *github.Milestone, dryRun bool) error {
+	var current *github.Milestone
+	err := WithRetry(ctx, func() (err error) {
+		var resp *github.Response
+		current, resp, err = issues.GetMilestone(ctx, g.Owner, g.Repo, milestone.GetNumber())
+		LogRate(ctx, resp)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("getting milestone %s: %+v", milestone.GetTitle(), err)
+	}
+
+	if strings.EqualFold(current.GetState(), "closed") {
+		loggerFrom(ctx).Debugf(LogFields{Milestone: milestone.GetTitle()}, "milestone %s is already closed", milestone.GetTitle())
+		return nil
+	}
+	if current.GetOpenIssues() > 0 {
+		loggerFrom(ctx).Debugf(LogFields{Milestone: milestone.GetTitle()}, "milestone %s still has %d open issues", milestone.GetTitle(), current.GetOpenIssues())
+		return nil
+	}
+
+	if dryRun {
+		loggerFrom(ctx).Infof(LogFields{Milestone: milestone.GetTitle()}, "dry run: would close completed milestone %s", milestone.GetTitle())
+		return nil
+	}
+
+	state := "closed"
+	err = WithRetry(ctx, func() error {
+		_, resp, err := issues.EditMilestone(ctx, g.Owner, g.Repo, milestone.GetNumber(), &github.Milestone{State: &state})
+		LogRate(ctx, resp)
+		return forbidden(resp, err, "Issues write")
+	})
+	if err != nil {
+		return fmt.Errorf("closing milestone %s: %+v", milestone.GetTitle(), err)
+	}
+
+	loggerFrom(ctx).Infof(LogFields{Milestone: milestone.GetTitle()}, "closed completed milestone %s", milestone.GetTitle())
+	return nil
+}
+
+// createNextMilestone creates the version milestone following the highest closed version milestone, bumped according
+// to opts.Bump. When there are no closed version milestones the scheme's first version, e.g. v0.1.0, is created. The
+// version is raised to opts.Min when below it, and bumped again past the titles of open milestones. It is due
+// opts.DueInDays days from now, if set.
+func (g GitHubIssue) createNextMilestone(ctx context.Context, issues issuesService, opts MilestoneOptions) (*github.Milestone, error) {
+	scheme := opts.Scheme
+	closed, err := g.listMilestones(ctx, issues, "closed")
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, m := range closed {
+		if title := NormalizeTitle(scheme, m.GetTitle()); scheme.Match(title) {
+			versions = append(versions, title)
+		}
+	}
+
+	latest := ""
+	if len(versions) > 0 {
+		sortVersions(scheme, versions)
+		latest = versions[len(versions)-1]
+	}
+	next, err := scheme.Next(latest, opts.Bump)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Min != "" && scheme.Compare(next, opts.Min) < 0 {
+		next = opts.Min
+	}
+
+	// open milestones that weren't selected, e.g. excluded or prerelease ones, may already have the next version's
+	// title, which GitHub refuses to create twice
+	open, err := g.listMilestones(ctx, issues, "open")
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool)
+	for _, m := range open {
+		taken[NormalizeTitle(scheme, m.GetTitle())] = true
+	}
+	for taken[next] {
+		loggerFrom(ctx).Debugf(LogFields{Milestone: next}, "an open milestone is already titled %s, skipping it", next)
+		if next, err = scheme.Next(next, opts.Bump); err != nil {
+			return nil, err
+		}
+	}
+
+	create := &github.Milestone{Title: &next}
+	if opts.DueInDays > 0 {
+		// GitHub only keeps the date, so the time of day is left out
+		due := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, opts.DueInDays)
+		create.DueOn = &due
+	}
+
+	var milestone *github.Milestone
+	err = WithRetry(ctx, func() (err error) {
+		var resp *github.Response
+		milestone, resp, err = issues.CreateMilestone(ctx, g.Owner, g.Repo, create)
+		LogRate(ctx, resp)
+		return forbidden(resp, err, "Issues write")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating milestone %s: %+v", next, err)
+	}
+	if milestone == nil || milestone.Number == nil {
+		return nil, fmt.Errorf("creating milestone %s: no milestone number returned", next)
+	}
+
+	loggerFrom(ctx).Infof(LogFields{Milestone: next}, "created version milestone: %s", next)
+	return milestone, nil
+}
+
+// getLinkedIssue returns the issues referenced in the issue's description by a word matching keywords, up to gap words
+// before the reference. References without an owner/repo prefix are resolved against the issue's own repository, and
+// issues referenced by URL must be on host.
+func (g GitHubIssue) getLinkedIssue(ctx context.Context, issues issuesService, keywords *regexp.Regexp, gap int, host string) ([]GitHubIssue, error) {
+	body, err := g.getDescription(ctx, issues)
+	if err != nil {
+		return nil, err
+	}
+
+	linked := parseLinkedIssues(body, keywords, gap, host, g.Owner, g.Repo)
+	if len(linked) == 0 {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "no special keywords found in issue description")
+	}
+	return linked, nil
+}
+
+// getCommitLinkedIssues returns the issues referenced by a word matching keywords in the messages of the pull
+// request's commits, which is where closing references end up when they were only written in a commit.
+func (g GitHubIssue) getCommitLinkedIssues(ctx context.Context, client *github.Client, keywords *regexp.Regexp, gap int, host string) ([]GitHubIssue, error) {
+	var linked []GitHubIssue
+	seen := make(map[GitHubIssue]bool)
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		var commits []*github.RepositoryCommit
+		var resp *github.Response
+		err := WithRetry(ctx, func() (err error) {
+			commits, resp, err = client.PullRequests.ListCommits(ctx, g.Owner, g.Repo, g.Id, opts)
+			LogRate(ctx, resp)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing commits of #%d: %+v", g.Id, err)
+		}
+
+		for _, c := range commits {
+			for _, li := range parseLinkedIssues(c.GetCommit().GetMessage(), keywords, gap, host, g.Owner, g.Repo) {
+				if !seen[li] {
+					seen[li] = true
+					linked = append(linked, li)
+				}
+			}
+		}
+
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return linked, nil
+}
+
+// labelFallbackWindow is how recently an issue must have been updated to be linked by Options.LabelFallback.
+const labelFallbackWindow = 30 * 24 * time.Hour
+
+// milestoneLabels returns the labels that labelMilestones maps to the milestone titled title, sorted by name.
+func milestoneLabels(labelMilestones map[string]string, title string) []string {
+	var labels []string
+	for label, t := range labelMilestones {
+		if t == title {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// getLabelledIssues returns the issues in the repository without a milestone that carry one of labels and were
+// updated within labelFallbackWindow, leaving out pull requests and the issue itself.
+func (g GitHubIssue) getLabelledIssues(ctx context.Context, issues issuesService, labels []string) ([]GitHubIssue, error) {
+	if len(labels) == 0 {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "no labels are mapped to the milestone, skipping the label fallback")
+		return nil, nil
+	}
+
+	var labelled []GitHubIssue
+	seen := map[GitHubIssue]bool{g: true}
+	for _, label := range labels {
+		opts := &github.IssueListByRepoOptions{
+			Milestone:   "none",
+			State:       "all",
+			Labels:      []string{label},
+			Since:       time.Now().Add(-labelFallbackWindow),
+			ListOptions: github.ListOptions{PerPage: 100},
+		}
+		for {
+			var page []*github.Issue
+			var resp *github.Response
+			err := WithRetry(ctx, func() (err error) {
+				page, resp, err = issues.ListByRepo(ctx, g.Owner, g.Repo, opts)
+				LogRate(ctx, resp)
+				return err
+			})
+			if err != nil {
+				return nil, fmt.Errorf("listing issues labelled %s: %+v", label, err)
+			}
+
+			for _, issue := range page {
+				li := GitHubIssue{g.Owner, g.Repo, issue.GetNumber()}
+				if issue.IsPullRequest() || seen[li] {
+					continue
+				}
+				seen[li] = true
+				labelled = append(labelled, li)
+			}
+
+			if resp == nil || resp.NextPage == 0 {
+				break
+			}
+			opts.Page = resp.NextPage
+		}
+	}
+
+	loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "found %d issues labelled %s without a milestone", len(labelled), strings.Join(labels, ", "))
+	return labelled, nil
+}
+
+// getReferencedIssues returns the issues referenced in the issue's description by one of phrases, such as
+// "Part of #100", which GitHub doesn't close when the issue is.
+func (g GitHubIssue) getReferencedIssues(ctx context.Context, issues issuesService, phrases [][]string, host string) ([]GitHubIssue, error) {
+	body, err := g.getDescription(ctx, issues)
+	if err != nil {
+		return nil, err
+	}
+	return parseReferencedIssues(body, phrases, host, g.Owner, g.Repo), nil
+}
+
+// get fetches the issue, retrying transient failures. The Linker's issuesService caches issues for the run, so an issue
+// read more than once is only fetched the first time. GitHub's response is returned too, so callers can tell an issue
+// that doesn't exist or can't be read from other failures.
+func (g GitHubIssue) get(ctx context.Context, issues issuesService) (*fullIssue, *github.Response, error) {
+	var issue *fullIssue
+	var resp *github.Response
+	err := WithRetry(ctx, func() (err error) {
+		issue, resp, err = issues.Get(ctx, g.Owner, g.Repo, g.Id)
+		LogRate(ctx, resp)
+		return err
+	})
+	if err != nil {
+		// wrapped with %w so rate limit errors can still be told apart by noAccess
+		return nil, resp, fmt.Errorf("getting issue %s: %w", g, err)
+	}
+	return issue, resp, nil
+}
+
+// getDescription returns the body of the issue, empty when it has none.
+func (g GitHubIssue) getDescription(ctx context.Context, issues issuesService) (string, error) {
+	issue, _, err := g.get(ctx, issues)
+	if err != nil {
+		return "", err
+	}
+	return issue.GetBody(), nil
+}
+
+// keywordMatcher reports whether a keyword starts at tokens[i], returning the number of tokens it spans and the text
+// following a colon in its last token, e.g. "#1" in "Fixes:#1".
+type keywordMatcher func(tokens []string, i int) (n int, rest string, ok bool)
+
+// parseLinkedIssues returns the issues referenced by closing keywords in body, in the order they first appear.
+// A keyword, optionally followed by a colon, may be followed by a list of issues joined by commas and/or "and", e.g.
+// "Fixes: #1, #2 and #3". Issues may be referenced in another repository as owner/repo#123 or by their URL, e.g.
+// https://github.com/owner/repo/issues/123 where host is github.com, bare references belong to owner/repo. Up to gap
+// other words may come between the keyword and the first issue, e.g. "fixes the issue #12" with a gap of 2.
+func parseLinkedIssues(body string, keywords *regexp.Regexp, gap int, host string, owner string, repo string) []GitHubIssue {
+	return parseReferences(body, host, owner, repo, gap, func(tokens []string, i int) (int, string, bool) {
+		word, rest := splitKeyword(tokens[i])
+		return 1, rest, keywords.MatchString(word)
+	})
+}
+
+// parseReferencedIssues returns the issues referenced in body by one of phrases, each given as its lower case words,
+// e.g. "Part of #100, #101". The references are parsed the same way as by parseLinkedIssues.
+func parseReferencedIssues(body string, phrases [][]string, host string, owner string, repo string) []GitHubIssue {
+	return parseReferences(body, host, owner, repo, 0, func(tokens []string, i int) (int, string, bool) {
+		for _, p := range phrases {
+			if len(p) == 0 || i+len(p) > len(tokens) {
+				continue
+			}
+			last, rest := splitKeyword(tokens[i+len(p)-1])
+			ok := strings.EqualFold(last, p[len(p)-1])
+			for k := 0; ok && k < len(p)-1; k++ {
+				word, _ := splitKeyword(tokens[i+k])
+				ok = strings.EqualFold(word, p[k])
+			}
+			if ok {
+				return len(p), rest, true
+			}
+		}
+		return 0, "", false
+	})
+}
+
+// openingPunctuation and closingPunctuation are the markdown and punctuation that may surround keywords and
+// references, e.g. "(closes #34)", "**Fixes**" or "#12.".
+const (
+	openingPunctuation = "([{<*_`'\""
+	closingPunctuation = ")]}>*_`'\".,;!?"
+)
+
+// splitKeyword splits a token at its first colon, returning the text before it without any surrounding punctuation
+// and the text after it, e.g. "Fixes" and "#1" for "**Fixes**:#1".
+func splitKeyword(s string) (string, string) {
+	s = strings.TrimLeft(s, openingPunctuation)
+	word, rest := s, ""
+	if c := strings.Index(s, ":"); c >= 0 {
+		word, rest = s[:c], s[c+1:]
+	}
+	return strings.TrimRight(word, closingPunctuation), rest
+}
+
+// parseReferences returns the issues listed after each keyword found by match in body, in the order they first appear.
+// The list may start up to gap words after the keyword. Issue URLs are only references when they are on host, as the
+// same path on another site, e.g. GitLab, is a different issue.
+func parseReferences(body string, host string, owner string, repo string, gap int, match keywordMatcher) []GitHubIssue {
+	// any whitespace separates tokens, so references on their own line or after CRLF line endings are found too
+	bodySplit := strings.Fields(body)
+	// references must make up the whole token, so malformed ones such as #123abc are rejected instead of read as #123
+	issue := regexp.MustCompile(`^(?:([A-Za-z0-9_.-]+)/([A-Za-z0-9_.-]+))?#([0-9]+)$`)
+	issueURL := regexp.MustCompile(`^https?://(?i:(?:www\.)?` + regexp.QuoteMeta(host) + `)/([A-Za-z0-9_.-]+)/([A-Za-z0-9_.-]+)/issues/([0-9]+)/?(?:[#?]\S*)?$`)
+	// keys of other trackers that GitHub autolinks, e.g. JIRA-123 or ABC-5, are never issues, but don't end a list
+	// either. Only upper case keys count, so words such as covid-19 still end the list.
+	autolink := regexp.MustCompile(`^[A-Z][A-Z0-9]*-[0-9]+$`)
+	// punctuation around the reference is removed before matching it, e.g. "(#34)," or "#12."
+	trim := func(token string) string {
+		return strings.TrimRight(strings.TrimLeft(token, openingPunctuation), closingPunctuation)
+	}
+	find := func(token string) []string {
+		ref := trim(token)
+		if match := issue.FindStringSubmatch(ref); match != nil {
+			return match
+		}
+		return issueURL.FindStringSubmatch(ref)
+	}
+
+	var issues []GitHubIssue
+	seen := make(map[GitHubIssue]bool)
+	for i := range bodySplit {
+		n, rest, ok := match(bodySplit, i)
+		if !ok {
+			continue
+		}
+
+		refs := bodySplit[i+n:]
+		if rest != "" {
+			refs = append([]string{rest}, refs...)
+		}
+
+		for skipped := 0; skipped < gap && len(refs) > 1 && find(refs[0]) == nil; skipped++ {
+			refs = refs[1:]
+		}
+
+		// consume the issue numbers following the keyword for as long as the list continues
+		for j := 0; j < len(refs); j++ {
+			next := refs[j]
+			match := find(next)
+			if match == nil && !autolink.MatchString(trim(next)) {
+				break
+			}
+
+			if match != nil {
+				li := GitHubIssue{owner, repo, 0}
+				if match[1] != "" {
+					li.Owner, li.Repo = match[1], match[2]
+				}
+				li.Id, _ = strconv.Atoi(match[3])
+				if !seen[li] {
+					seen[li] = true
+					issues = append(issues, li)
+				}
+			}
+
+			if j+1 < len(refs) && strings.EqualFold(refs[j+1], "and") {
+				j++
+				continue
+			}
+			if !strings.HasSuffix(next, ",") {
+				break
+			}
+		}
+	}
+
+	return issues
+}
+
+// NewReferencePhrases splits the comma-separated list of non-closing keywords into their lower case words, using
+// "part of" and "relates to" when the list is empty.
+func NewReferencePhrases(list string) [][]string {
+	if strings.TrimSpace(list) == "" {
+		list = "part of,relates to"
+	}
+
+	var phrases [][]string
+	for _, p := range strings.Split(list, ",") {
+		if words := strings.Fields(strings.ToLower(p)); len(words) > 0 {
+			phrases = append(phrases, words)
+		}
+	}
+	return phrases
+}
+
+// Change is a milestone set on, or removed from, an issue or pull request. From and To are milestone titles, empty
+// when there is none.
+type Change struct {
+	Issue GitHubIssue
+	Title string
+	From  string
+	To    string
+}
+
+// removeMilestone clears the issue's milestone if it is set to milestone, undoing updateMilestone. When dryRun is set
+// the change is only logged. The change is returned, or nil when the issue is left alone.
+func (g GitHubIssue) removeMilestone(ctx context.Context, issues issuesService, milestone *github.Milestone, dryRun bool) (*Change, error) {
+	issue, _, err := g.get(ctx, issues)
+	if err != nil {
+		return nil, err
+	}
+
+	if issue.Milestone == nil || issue.Milestone.GetNumber() != milestone.GetNumber() {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String(), Milestone: milestone.GetTitle()}, "github issue #%d isn't linked to milestone %s, skipping", g.Id, milestone.GetTitle())
+		return nil, nil
+	}
+	change := &Change{Issue: g, Title: issue.GetTitle(), From: issue.Milestone.GetTitle()}
+
+	if dryRun {
+		loggerFrom(ctx).Infof(LogFields{Issue: g.String(), Milestone: milestone.GetTitle()}, "dry run: would remove milestone %s (%d) from %s", milestone.GetTitle(), milestone.GetNumber(), g)
+		return change, nil
+	}
+
+	err = WithRetry(ctx, func() error {
+		_, resp, err := issues.RemoveMilestone(ctx, g.Owner, g.Repo, g.Id)
+		LogRate(ctx, resp)
+		return forbidden(resp, err, "Issues write")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("removing milestone from issue #%d: %+v", g.Id, err)
+	}
+
+	loggerFrom(ctx).Infof(LogFields{Issue: g.String(), Milestone: milestone.GetTitle()}, "removed milestone %s (%d) from %s", milestone.GetTitle(), milestone.GetNumber(), g)
+	return change, nil
+}
+
+// NewKeywordRegexp compiles a comma-separated list of closing keywords into a case-insensitive regular expression
+// matching any one of them as a whole word. Each keyword may itself be a pattern, e.g. "address(es)?". An empty list
+// returns DefaultKeywords.
+func NewKeywordRegexp(list string) (*regexp.Regexp, error) {
+	var words []string
+	for _, w := range strings.Split(list, ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			words = append(words, w)
+		}
+	}
+
+	if len(words) == 0 {
+		return DefaultKeywords, nil
+	}
+
+	r, err := regexp.Compile(`^(?i:` + strings.Join(words, "|") + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("compiling closing keywords %q: %+v", list, err)
+	}
+	return r, nil
+}
+
+// UpdateOptions controls how updateMilestone changes an issue.
+type UpdateOptions struct {
+	// DryRun only logs the change instead of making it.
+	DryRun bool
+	// ForceReassign moves issues that already have a different milestone.
+	ForceReassign bool
+	// IncludeNotPlanned links issues that were closed as not planned, which are skipped otherwise.
+	IncludeNotPlanned bool
+	// IncludeOpen links issues that are still open, as done for issues referenced without being closed.
+	IncludeOpen bool
+	// IncludePullRequests links references that turn out to be pull requests rather than issues, which are skipped
+	// otherwise. It is always set for the pull request being linked.
+	IncludePullRequests bool
+	// Verify fetches the issue again after setting its milestone and fails when the milestone didn't stick.
+	Verify bool
+	// SkipNoAccess leaves the issue alone with a warning when the token may not edit it, as is done for issues in
+	// other repositories.
+	SkipNoAccess bool
+}
+
+// updateMilestone assigns the milestone to the issue if it is closed and has no milestone yet, or a different one when
+// opts.ForceReassign is set. Issues closed as not planned are left alone unless opts.IncludeNotPlanned is set, as is
+// every issue once the milestone itself is closed. The change is returned, or nil when the issue is left alone.
+func (g GitHubIssue) updateMilestone(ctx context.Context, issues issuesService, milestone *github.Milestone, opts UpdateOptions) (*Change, error) {
+	milestoneId := milestone.GetNumber()
+
+	issue, _, err := g.get(ctx, issues)
+	if err != nil {
+		return nil, err
+	}
+
+	if issue.State == nil {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "github issue #%d has no state, skipping", g.Id)
+		return nil, nil
+	}
+
+	if !opts.IncludePullRequests && issue.IsPullRequest() {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "#%d is a pull request, not an issue, skipping", g.Id)
+		return nil, nil
+	}
+
+	if issue.Milestone != nil && (!opts.ForceReassign || issue.Milestone.GetNumber() == milestoneId) {
+		// partial responses may leave out the title, in which case the milestone is named by its number
+		current := issue.Milestone.GetTitle()
+		if issue.Milestone.Title == nil {
+			current = fmt.Sprintf("number %d", issue.Milestone.GetNumber())
+		}
+
+		// a milestone set by hand is kept, but one that differs from the selected milestone may well be a mistake
+		if issue.Milestone.GetNumber() != milestoneId {
+			loggerFrom(ctx).Warnf(LogFields{Issue: g.String(), Milestone: issue.Milestone.GetTitle()}, "github issue #%d has milestone %s, not %s which it would have been linked to: leaving it as is, set FORCE_REASSIGN to move it", g.Id, current, milestone.GetTitle())
+			return nil, nil
+		}
+
+		// nothing is edited when the issue already has the milestone, so re-runs don't change anything
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String(), Milestone: issue.Milestone.GetTitle()}, "github issue #%d already has milestone %s", g.Id, current)
+		return nil, nil
+	}
+
+	if !opts.IncludeOpen && !strings.EqualFold(*issue.State, "closed") {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "github issue #%d is not closed, skipping", g.Id)
+		return nil, nil
+	}
+
+	if !opts.IncludeNotPlanned && strings.EqualFold(*issue.State, "closed") && issue.StateReason == "not_planned" {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "github issue #%d was closed as not planned, skipping", g.Id)
+		return nil, nil
+	}
+
+	change := &Change{Issue: g, Title: issue.GetTitle(), From: issue.Milestone.GetTitle(), To: milestone.GetTitle()}
+	if issue.Milestone != nil {
+		loggerFrom(ctx).Infof(LogFields{Issue: g.String(), Milestone: milestone.GetTitle()}, "moving github issue #%d from milestone %s to %s", g.Id, issue.Milestone.GetTitle(), milestone.GetTitle())
+	}
+
+	if opts.DryRun {
+		loggerFrom(ctx).Infof(LogFields{Issue: g.String(), Milestone: milestone.GetTitle()}, "dry run: would set milestone %s (%d) on %s", milestone.GetTitle(), milestoneId, g)
+		return change, nil
+	}
+
+	// the milestone may have been closed since it was selected, e.g. while a release is being cut
+	var current *github.Milestone
+	err = WithRetry(ctx, func() (err error) {
+		var resp *github.Response
+		current, resp, err = issues.GetMilestone(ctx, g.Owner, g.Repo, milestoneId)
+		LogRate(ctx, resp)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting milestone %s: %+v", milestone.GetTitle(), err)
+	}
+	if strings.EqualFold(current.GetState(), "closed") {
+		loggerFrom(ctx).Warnf(LogFields{Issue: g.String(), Milestone: milestone.GetTitle()}, "milestone %s (%d) was closed, not linking %s", milestone.GetTitle(), milestoneId, g)
+		return nil, nil
+	}
+
+	// GitHub occasionally answers an edit with an empty body, so the edited issue is only checked when there is one
+	var edited *github.Issue
+	var resp *github.Response
+	err = WithRetry(ctx, func() (err error) {
+		edited, resp, err = issues.Edit(ctx, g.Owner, g.Repo, g.Id, &github.IssueRequest{Milestone: &milestoneId})
+		LogRate(ctx, resp)
+		return err
+	})
+	if opts.SkipNoAccess && noAccess(resp, err) {
+		loggerFrom(ctx).Warnf(LogFields{Issue: g.String(), Milestone: milestone.GetTitle()}, "can't edit %s, the token may lack access to %s/%s: skipping it", g, g.Owner, g.Repo)
+		return nil, nil
+	}
+	if err = forbidden(resp, err, "Issues write"); err != nil {
+		return nil, fmt.Errorf("updating milestone on issue #%d: %+v", g.Id, err)
+	}
+	if edited != nil && edited.Milestone != nil && edited.Milestone.GetNumber() != milestoneId {
+		loggerFrom(ctx).Warnf(LogFields{Issue: g.String(), Milestone: milestone.GetTitle()}, "github issue #%d was edited but reports milestone %s", g.Id, edited.Milestone.GetTitle())
+	}
+
+	if opts.Verify {
+		updated, _, err := g.get(ctx, issues)
+		if err != nil {
+			return nil, fmt.Errorf("verifying milestone on issue #%d: %+v", g.Id, err)
+		}
+		if updated.GetMilestone().GetNumber() != milestoneId {
+			return nil, fmt.Errorf("verifying milestone on issue #%d: expected milestone %s (%d), found %q", g.Id, milestone.GetTitle(), milestoneId, updated.GetMilestone().GetTitle())
+		}
+	}
+
+	loggerFrom(ctx).Infof(LogFields{Issue: g.String(), Milestone: milestone.GetTitle()}, "set milestone %s (%d) on %s", milestone.GetTitle(), milestoneId, g)
+	return change, nil
+}
@@ -0,0 +1,1219 @@
+package linker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+var testPR = GitHubIssue{"owner", "repo", 1}
+
+func TestGetMilestone(t *testing.T) {
+	cases := []struct {
+		name       string
+		milestones [][2]string
+		opts       MilestoneOptions
+		err        error
+		expected   string
+	}{
+		{
+			name:       "lowest",
+			milestones: [][2]string{{"v1.1.0", "open"}, {"v1.0.0", "open"}, {"v1.2.0", "open"}},
+			opts:       MilestoneOptions{Selection: SelectionLowest},
+			expected:   "v1.0.0",
+		},
+		{
+			name:       "highest",
+			milestones: [][2]string{{"v1.1.0", "open"}, {"v1.0.0", "open"}, {"v1.2.0", "open"}},
+			opts:       MilestoneOptions{Selection: SelectionHighest},
+			expected:   "v1.2.0",
+		},
+		{
+			name:       "closed and non-version milestones are ignored",
+			milestones: [][2]string{{"v0.9.0", "closed"}, {"Backlog", "open"}, {"v1.0.0", "open"}},
+			opts:       MilestoneOptions{Selection: SelectionLowest},
+			expected:   "v1.0.0",
+		},
+		{
+			name:       "no open version milestones",
+			milestones: [][2]string{{"Backlog", "open"}, {"v1.0.0", "closed"}},
+			opts:       MilestoneOptions{Selection: SelectionLowest},
+		},
+		{
+			name: "no milestones",
+			opts: MilestoneOptions{Selection: SelectionLowest},
+		},
+		{
+			name:       "listing fails",
+			milestones: [][2]string{{"v1.0.0", "open"}},
+			opts:       MilestoneOptions{Selection: SelectionLowest},
+			err:        errors.New("boom"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			for i, m := range tc.milestones {
+				issues.addMilestone("owner", "repo", i+1, m[0], m[1])
+			}
+			if tc.err != nil {
+				issues.errs["ListMilestones"] = tc.err
+			}
+			tc.opts.Scheme = SemverScheme{}
+
+			milestone, created, err := testPR.getMilestone(context.Background(), issues, tc.opts)
+			if tc.err != nil {
+				if err == nil {
+					t.Fatalf("expected an error, got milestone %v", milestone)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if created {
+				t.Errorf("expected no milestone to be created")
+			}
+			if tc.expected == "" {
+				if milestone != nil {
+					t.Errorf("expected no milestone, got %s", milestone.GetTitle())
+				}
+				return
+			}
+			if milestone.GetTitle() != tc.expected {
+				t.Errorf("expected milestone %s, got %s", tc.expected, milestone.GetTitle())
+			}
+		})
+	}
+}
+
+func TestGetLinkedIssue(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     *string
+		err      error
+		expected []GitHubIssue
+	}{
+		{
+			name:     "closing keyword",
+			body:     github.String("Fixes #12"),
+			expected: []GitHubIssue{{"owner", "repo", 12}},
+		},
+		{
+			name:     "several keywords and repositories",
+			body:     github.String("Closes #3 and resolves other/repo#4"),
+			expected: []GitHubIssue{{"owner", "repo", 3}, {"other", "repo", 4}},
+		},
+		{
+			name: "no keywords",
+			body: github.String("Refactors the parser, see #12"),
+		},
+		{
+			name: "no description",
+		},
+		{
+			name: "getting the pull request fails",
+			err:  errors.New("boom"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			issues.addIssue(testPR, "closed", "").Body = tc.body
+			if tc.err != nil {
+				issues.errs["Get"] = tc.err
+			}
+
+			linked, err := testPR.getLinkedIssue(context.Background(), issues, DefaultKeywords, 0, "github.com")
+			if tc.err != nil {
+				if err == nil {
+					t.Fatalf("expected an error, got issues %v", linked)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(linked, tc.expected) {
+				t.Errorf("expected issues %v, got %v", tc.expected, linked)
+			}
+		})
+	}
+}
+
+func TestUpdateMilestone(t *testing.T) {
+	issue := GitHubIssue{"owner", "repo", 12}
+
+	cases := []struct {
+		name string
+		// state is the issue's state, none when empty
+		state     string
+		milestone int
+		opts      UpdateOptions
+		errs      map[string]error
+		edited    bool
+		changed   bool
+		err       bool
+	}{
+		{
+			name:    "closed issue without a milestone",
+			state:   "closed",
+			edited:  true,
+			changed: true,
+		},
+		{
+			name:      "already linked",
+			state:     "closed",
+			milestone: 2,
+		},
+		{
+			name:      "manually set milestone is kept",
+			state:     "closed",
+			milestone: 3,
+		},
+		{
+			name:      "manually set milestone is moved when forced",
+			state:     "closed",
+			milestone: 3,
+			opts:      UpdateOptions{ForceReassign: true},
+			edited:    true,
+			changed:   true,
+		},
+		{
+			name:  "open issue",
+			state: "open",
+		},
+		{
+			name: "issue without a state",
+		},
+		{
+			name:    "dry run",
+			state:   "closed",
+			opts:    UpdateOptions{DryRun: true},
+			changed: true,
+		},
+		{
+			name:  "getting the issue fails",
+			state: "closed",
+			errs:  map[string]error{"Get": errors.New("boom")},
+			err:   true,
+		},
+		{
+			name:  "editing the issue is forbidden",
+			state: "closed",
+			errs:  map[string]error{"Edit": statusError(http.StatusForbidden)},
+			err:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			milestone := issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+			issues.addMilestone("owner", "repo", 3, "Backlog", "open")
+			i := issues.addIssue(issue, tc.state, "")
+			if tc.state == "" {
+				i.State = nil
+			}
+			if tc.milestone != 0 {
+				i.Milestone = issues.milestone("owner", "repo", tc.milestone)
+			}
+			for method, err := range tc.errs {
+				issues.errs[method] = err
+			}
+
+			change, err := issue.updateMilestone(context.Background(), issues, milestone, tc.opts)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected an error, got change %v", change)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if edited := len(issues.edits) > 0; edited != tc.edited {
+				t.Errorf("expected edited to be %t, got edits %v", tc.edited, issues.edits)
+			}
+			if (change != nil) != tc.changed {
+				t.Errorf("expected changed to be %t, got %v", tc.changed, change)
+			}
+			if change != nil && change.To != "v1.0.0" {
+				t.Errorf("expected the change to be to v1.0.0, got %s", change.To)
+			}
+		})
+	}
+}
+
+func TestParseLinkedIssues(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     string
+		expected []GitHubIssue
+	}{
+		{
+			name: "keyword is the last word",
+			body: "This fixes",
+		},
+		{
+			name: "keyword is the whole body",
+			body: "fixes",
+		},
+		{
+			name:     "same repository",
+			body:     "Fixes #12",
+			expected: []GitHubIssue{{"owner", "repo", 12}},
+		},
+		{
+			name:     "other repository",
+			body:     "Fixes other/project#34",
+			expected: []GitHubIssue{{"other", "project", 34}},
+		},
+		{
+			name:     "other repository by url",
+			body:     "Fixes https://github.com/other/project/issues/34",
+			expected: []GitHubIssue{{"other", "project", 34}},
+		},
+		{
+			name:     "same repository by url",
+			body:     "Resolves https://github.com/owner/repo/issues/12.",
+			expected: []GitHubIssue{{"owner", "repo", 12}},
+		},
+		{
+			name:     "colon and space",
+			body:     "Fixes: #5",
+			expected: []GitHubIssue{{"owner", "repo", 5}},
+		},
+		{
+			name:     "colon without space",
+			body:     "fixes:#7",
+			expected: []GitHubIssue{{"owner", "repo", 7}},
+		},
+		{
+			name:     "colon after closes",
+			body:     "Closes: #9",
+			expected: []GitHubIssue{{"owner", "repo", 9}},
+		},
+		{
+			name: "number followed by letters",
+			body: "Fixes #123abc",
+		},
+		{
+			name: "number followed by a hyphen",
+			body: "Fixes #12-foo",
+		},
+		{
+			name:     "markdown bullets",
+			body:     "- Fixes #1\n* closes #2\n1. Resolves #3",
+			expected: []GitHubIssue{{"owner", "repo", 1}, {"owner", "repo", 2}, {"owner", "repo", 3}},
+		},
+		{
+			name:     "parentheses",
+			body:     "Handle empty bodies (fixes #12)",
+			expected: []GitHubIssue{{"owner", "repo", 12}},
+		},
+		{
+			name:     "trailing punctuation",
+			body:     "Fixes #12. Closes #13, resolves #14!",
+			expected: []GitHubIssue{{"owner", "repo", 12}, {"owner", "repo", 13}, {"owner", "repo", 14}},
+		},
+		{
+			name:     "new lines",
+			body:     "Some context.\nFixes #1\nfixes\n#2\n",
+			expected: []GitHubIssue{{"owner", "repo", 1}, {"owner", "repo", 2}},
+		},
+		{
+			name:     "crlf line endings",
+			body:     "Some context.\r\nFixes #1\r\nResolves #2\r\n",
+			expected: []GitHubIssue{{"owner", "repo", 1}, {"owner", "repo", 2}},
+		},
+		{
+			name:     "tabs",
+			body:     "Fixes\t#1\tcloses\t#2",
+			expected: []GitHubIssue{{"owner", "repo", 1}, {"owner", "repo", 2}},
+		},
+		{
+			name: "custom autolink",
+			body: "Fixes JIRA-123",
+		},
+		{
+			name: "short custom autolink",
+			body: "Closes ABC-5.",
+		},
+		{
+			name:     "custom autolink and issue",
+			body:     "Fixes JIRA-123, fixes #12",
+			expected: []GitHubIssue{{"owner", "repo", 12}},
+		},
+		{
+			name: "custom autolink with a hash",
+			body: "Fixes #JIRA-123",
+		},
+		{
+			name: "hyphenated word",
+			body: "Fixes covid-19, #12",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			linked := parseLinkedIssues(tc.body, DefaultKeywords, 0, "github.com", "owner", "repo")
+			if !reflect.DeepEqual(linked, tc.expected) {
+				t.Errorf("expected issues %v, got %v", tc.expected, linked)
+			}
+		})
+	}
+}
+
+func TestParseLinkedIssuesHost(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     string
+		host     string
+		expected []GitHubIssue
+	}{
+		{
+			name:     "github",
+			body:     "Fixes https://github.com/other/project/issues/34",
+			host:     "github.com",
+			expected: []GitHubIssue{{"other", "project", 34}},
+		},
+		{
+			name:     "www and upper case",
+			body:     "Fixes https://www.GitHub.com/other/project/issues/34",
+			host:     "github.com",
+			expected: []GitHubIssue{{"other", "project", 34}},
+		},
+		{
+			name: "other site",
+			body: "Fixes https://gitlab.com/other/project/issues/34",
+			host: "github.com",
+		},
+		{
+			name: "host as a prefix",
+			body: "Fixes https://github.com.example.org/other/project/issues/34",
+			host: "github.com",
+		},
+		{
+			name:     "github enterprise server",
+			body:     "Fixes https://github.example.com/other/project/issues/34",
+			host:     "github.example.com",
+			expected: []GitHubIssue{{"other", "project", 34}},
+		},
+		{
+			name: "github from github enterprise server",
+			body: "Fixes https://github.com/other/project/issues/34",
+			host: "github.example.com",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			linked := parseLinkedIssues(tc.body, DefaultKeywords, 0, tc.host, "owner", "repo")
+			if !reflect.DeepEqual(linked, tc.expected) {
+				t.Errorf("expected issues %v, got %v", tc.expected, linked)
+			}
+		})
+	}
+}
+
+func TestWebHost(t *testing.T) {
+	for base, expected := range map[string]string{
+		"https://api.github.com/":                 "github.com",
+		"https://github.example.com/api/v3/":      "github.example.com",
+		"http://127.0.0.1:8080/":                  "github.com",
+		"https://github.example.com:8443/api/v3/": "github.example.com:8443",
+	} {
+		client := github.NewClient(nil)
+		client.BaseURL, _ = url.Parse(base)
+		if host := webHost(client); host != expected {
+			t.Errorf("expected host %s for %s, got %s", expected, base, host)
+		}
+	}
+}
+
+func TestParseLinkedIssuesKeywordGap(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     string
+		gap      int
+		expected []GitHubIssue
+	}{
+		{
+			name:     "double space",
+			body:     "Fixes  #12",
+			expected: []GitHubIssue{{"owner", "repo", 12}},
+		},
+		{
+			name: "word in between without a gap",
+			body: "Fixes the #12",
+		},
+		{
+			name:     "word in between",
+			body:     "Fixes the #12",
+			gap:      1,
+			expected: []GitHubIssue{{"owner", "repo", 12}},
+		},
+		{
+			name: "more words in between than the gap",
+			body: "fixes the issue #12",
+			gap:  1,
+		},
+		{
+			name:     "words and a double space in between",
+			body:     "fixes the  issue #12",
+			gap:      2,
+			expected: []GitHubIssue{{"owner", "repo", 12}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			linked := parseLinkedIssues(tc.body, DefaultKeywords, tc.gap, "github.com", "owner", "repo")
+			if !reflect.DeepEqual(linked, tc.expected) {
+				t.Errorf("expected issues %v, got %v", tc.expected, linked)
+			}
+		})
+	}
+}
+
+func TestGetMilestonePaginates(t *testing.T) {
+	issues := newFakeIssues()
+	issues.pageSize = 1
+	issues.addMilestone("owner", "repo", 1, "v1.1.0", "open")
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+
+	milestone, _, err := testPR.getMilestone(context.Background(), issues, MilestoneOptions{Selection: SelectionLowest, Scheme: SemverScheme{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if milestone.GetTitle() != "v1.0.0" {
+		t.Errorf("expected the milestone on the second page, v1.0.0, got %s", milestone.GetTitle())
+	}
+	if issues.calls["ListMilestones"] != 2 {
+		t.Errorf("expected 2 pages to be listed, got %d", issues.calls["ListMilestones"])
+	}
+}
+
+func TestGetMilestoneListsOpenMilestones(t *testing.T) {
+	issues := newFakeIssues()
+	issues.pageSize = 1
+	issues.addMilestone("owner", "repo", 1, "v0.9.0", "closed")
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "closed")
+	issues.addMilestone("owner", "repo", 3, "v1.1.0", "open")
+
+	milestone, _, err := testPR.getMilestone(context.Background(), issues, MilestoneOptions{Selection: SelectionLowest, Scheme: SemverScheme{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if milestone.GetTitle() != "v1.1.0" {
+		t.Errorf("expected the open milestone v1.1.0, got %s", milestone.GetTitle())
+	}
+	// the closed milestones would take a page each if they were fetched
+	if len(issues.listOpts) != 1 {
+		t.Fatalf("expected 1 page to be listed, got %d", len(issues.listOpts))
+	}
+	if opts := issues.listOpts[0]; opts.State != "open" || opts.Sort != "due_on" || opts.Direction != "asc" {
+		t.Errorf("expected open milestones to be listed by due date, got %+v", opts)
+	}
+}
+
+func TestNewKeywordRegexp(t *testing.T) {
+	keywords, err := NewKeywordRegexp("addresses, fixes")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	linked := parseLinkedIssues("Addresses #4, closes #5", keywords, 0, "github.com", "owner", "repo")
+	expected := []GitHubIssue{{"owner", "repo", 4}}
+	if !reflect.DeepEqual(linked, expected) {
+		t.Errorf("expected issues %v, got %v", expected, linked)
+	}
+
+	if keywords, _ := NewKeywordRegexp(" "); keywords != DefaultKeywords {
+		t.Errorf("expected an empty list to use the default keywords, got %s", keywords)
+	}
+	if _, err := NewKeywordRegexp("address(es"); err == nil {
+		t.Errorf("expected an invalid keyword to fail")
+	}
+}
+
+func TestDefaultKeywords(t *testing.T) {
+	for _, body := range []string{
+		"close #1", "Closes #1", "Closed #3", "fix #1", "FIXES #1", "Fixed #1", "resolve #1", "Resolves #1", "resolved #9",
+	} {
+		t.Run(body, func(t *testing.T) {
+			linked := parseLinkedIssues(body, DefaultKeywords, 0, "github.com", "owner", "repo")
+			if len(linked) != 1 {
+				t.Errorf("expected one issue, got %v", linked)
+			}
+		})
+	}
+
+	for _, body := range []string{"closing #1", "fixing #1", "resolving #1", "prefix #1"} {
+		t.Run(body, func(t *testing.T) {
+			if linked := parseLinkedIssues(body, DefaultKeywords, 0, "github.com", "owner", "repo"); len(linked) != 0 {
+				t.Errorf("expected no issues, got %v", linked)
+			}
+		})
+	}
+}
+
+func TestUpdateMilestoneForceReassign(t *testing.T) {
+	for _, force := range []bool{false, true} {
+		t.Run(fmt.Sprintf("force %t", force), func(t *testing.T) {
+			issues := newFakeIssues()
+			milestone := issues.addMilestone("owner", "repo", 2, "v1.1.0", "open")
+			issue := GitHubIssue{"owner", "repo", 12}
+			issues.addIssue(issue, "closed", "").Milestone = issues.addMilestone("owner", "repo", 1, "v1.0.0", "open")
+
+			change, err := issue.updateMilestone(context.Background(), issues, milestone, UpdateOptions{ForceReassign: force})
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			if !force {
+				if change != nil || len(issues.edits) > 0 {
+					t.Errorf("expected the milestone to be kept, got change %+v and edits %v", change, issues.edits)
+				}
+				return
+			}
+			if change == nil || change.From != "v1.0.0" || change.To != "v1.1.0" {
+				t.Errorf("expected a move from v1.0.0 to v1.1.0, got %+v", change)
+			}
+			if expected := []fakeEdit{{issue, 2}}; !reflect.DeepEqual(issues.edits, expected) {
+				t.Errorf("expected edits %v, got %v", expected, issues.edits)
+			}
+		})
+	}
+}
+
+func TestGetMilestonePrerelease(t *testing.T) {
+	cases := []struct {
+		name              string
+		selection         string
+		includePrerelease bool
+		expected          string
+	}{
+		{"lowest including prereleases", SelectionLowest, true, "v1.2.0-rc1"},
+		{"highest including prereleases", SelectionHighest, true, "v1.4.0-rc1"},
+		{"lowest ignoring prereleases", SelectionLowest, false, "v1.2.0"},
+		{"highest ignoring prereleases", SelectionHighest, false, "v1.3.0"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			for i, title := range []string{"v1.3.0-beta1", "v1.2.0", "v1.2.0-rc1", "v1.3.0", "v1.4.0-rc1"} {
+				issues.addMilestone("owner", "repo", i+1, title, "open")
+			}
+
+			opts := MilestoneOptions{Selection: tc.selection, IncludePrerelease: tc.includePrerelease, Scheme: SemverScheme{}}
+			milestone, _, err := testPR.getMilestone(context.Background(), issues, opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if milestone.GetTitle() != tc.expected {
+				t.Errorf("expected milestone %s, got %s", tc.expected, milestone.GetTitle())
+			}
+		})
+	}
+}
+
+func TestGetMilestoneCalver(t *testing.T) {
+	cases := []struct {
+		selection string
+		expected  string
+	}{
+		{SelectionLowest, "v2024.05"},
+		{SelectionHighest, "v2024.11"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.selection, func(t *testing.T) {
+			issues := newFakeIssues()
+			for i, title := range []string{"v2024.11", "v2024.05.1", "v1.2.0", "v2024.05", "v2023.12", "v2024.13"} {
+				state := "open"
+				if title == "v2023.12" {
+					state = "closed"
+				}
+				issues.addMilestone("owner", "repo", i+1, title, state)
+			}
+
+			opts := MilestoneOptions{Selection: tc.selection, Scheme: CalverScheme{}}
+			milestone, _, err := testPR.getMilestone(context.Background(), issues, opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if milestone.GetTitle() != tc.expected {
+				t.Errorf("expected milestone %s, got %s", tc.expected, milestone.GetTitle())
+			}
+		})
+	}
+}
+
+func TestGetMilestonePattern(t *testing.T) {
+	scheme, err := NewPatternScheme(`Release (?P<version>[0-9.]+)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	cases := []struct {
+		selection string
+		expected  string
+	}{
+		{SelectionLowest, "Release 1.9"},
+		{SelectionHighest, "Release 1.10"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.selection, func(t *testing.T) {
+			issues := newFakeIssues()
+			for i, title := range []string{"Release 1.10", "v1.0.0", "Release 1.9", "Backlog"} {
+				issues.addMilestone("owner", "repo", i+1, title, "open")
+			}
+
+			opts := MilestoneOptions{Selection: tc.selection, Scheme: scheme}
+			milestone, _, err := testPR.getMilestone(context.Background(), issues, opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if milestone.GetTitle() != tc.expected {
+				t.Errorf("expected milestone %s, got %s", tc.expected, milestone.GetTitle())
+			}
+		})
+	}
+}
+
+func TestNewPatternSchemeRequiresVersionGroup(t *testing.T) {
+	if _, err := NewPatternScheme(`Release ([0-9.]+)`); err == nil {
+		t.Errorf("expected a pattern without a version group to be rejected")
+	}
+}
+
+func TestCloseMilestoneIfCompleted(t *testing.T) {
+	cases := []struct {
+		name       string
+		openIssues int
+		expected   string
+	}{
+		{"open issues left", 2, "open"},
+		{"no open issues", 0, "closed"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			m := issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+			m.OpenIssues = github.Int(tc.openIssues)
+
+			if err := testPR.closeMilestoneIfCompleted(context.Background(), issues, m, false); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if state := issues.milestone("owner", "repo", 2).GetState(); state != tc.expected {
+				t.Errorf("expected the milestone to be %s, got %s", tc.expected, state)
+			}
+		})
+	}
+}
+
+func TestRemoveMilestone(t *testing.T) {
+	cases := []struct {
+		name      string
+		milestone int
+		expected  []fakeEdit
+	}{
+		{"set to the target", 2, []fakeEdit{{testPR, 0}}},
+		{"set to another milestone", 3, nil},
+		{"no milestone", 0, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			target := issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+			issues.addMilestone("owner", "repo", 3, "v1.1.0", "open")
+			issues.addIssue(testPR, "closed", "").Milestone = issues.milestone("owner", "repo", tc.milestone)
+
+			change, err := testPR.removeMilestone(context.Background(), issues, target, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(issues.edits, tc.expected) {
+				t.Errorf("expected edits %v, got %v", tc.expected, issues.edits)
+			}
+			if (change != nil) != (tc.expected != nil) {
+				t.Errorf("expected a change to be returned only when the milestone is removed, got %+v", change)
+			}
+		})
+	}
+}
+
+func TestGetMilestoneCollidingTitles(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 3, "v1.0.0", "open")
+	issues.addMilestone("owner", "repo", 2, " 1.0.0", "open")
+	issues.addMilestone("owner", "repo", 4, "v1.1.0", "open")
+
+	milestone, _, err := testPR.getMilestone(context.Background(), issues, MilestoneOptions{Selection: SelectionLowest, Scheme: SemverScheme{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if milestone.GetNumber() != 2 {
+		t.Errorf("expected the older of the milestones for v1.0.0, 2, got %q (%d)", milestone.GetTitle(), milestone.GetNumber())
+	}
+}
+
+func TestUpdateMilestoneStateReason(t *testing.T) {
+	issue := GitHubIssue{"owner", "repo", 12}
+
+	cases := []struct {
+		reason            string
+		includeNotPlanned bool
+		linked            bool
+	}{
+		{"completed", false, true},
+		{"not_planned", false, false},
+		{"not_planned", true, true},
+		{"", false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%q include not planned %t", tc.reason, tc.includeNotPlanned), func(t *testing.T) {
+			issues := newFakeIssues()
+			milestone := issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+			issues.addIssue(issue, "closed", "")
+			issues.stateReasons[issue] = tc.reason
+
+			change, err := issue.updateMilestone(context.Background(), issues, milestone, UpdateOptions{IncludeNotPlanned: tc.includeNotPlanned})
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if linked := change != nil && len(issues.edits) == 1; linked != tc.linked {
+				t.Errorf("expected linked to be %t, got change %v and edits %v", tc.linked, change, issues.edits)
+			}
+			// the reason comes with the issue rather than being fetched separately
+			if issues.calls["Get"] != 1 {
+				t.Errorf("expected the issue to be fetched once, got %d", issues.calls["Get"])
+			}
+		})
+	}
+}
+
+func TestIssuesClientGet(t *testing.T) {
+	client := newTestClient(t, map[string]string{
+		"GET /repos/owner/repo/issues/12": `{"number": 12, "state": "closed", "state_reason": "not_planned", "milestone": {"number": 2, "title": "v1.0.0"}}`,
+	})
+
+	issue, _, err := issuesClient{client.Issues, client}.Get(context.Background(), "owner", "repo", 12)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if issue.GetNumber() != 12 || issue.GetState() != "closed" || issue.Milestone.GetTitle() != "v1.0.0" {
+		t.Errorf("expected the issue to be decoded, got %+v", issue.Issue)
+	}
+	if issue.StateReason != "not_planned" {
+		t.Errorf("expected state reason not_planned, got %q", issue.StateReason)
+	}
+}
+
+func TestGetMilestoneMinimum(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 1, "v0.9.0", "open")
+	issues.addMilestone("owner", "repo", 2, "v1.1.0", "open")
+	issues.addMilestone("owner", "repo", 3, "v1.2.0", "open")
+
+	opts := MilestoneOptions{Selection: SelectionLowest, Scheme: SemverScheme{}, Min: "v1.0.0"}
+	milestone, _, err := testPR.getMilestone(context.Background(), issues, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if milestone.GetTitle() != "v1.1.0" {
+		t.Errorf("expected the lowest milestone above the minimum, v1.1.0, got %s", milestone.GetTitle())
+	}
+}
+
+func TestCreateNextMilestone(t *testing.T) {
+	cases := []struct {
+		name       string
+		milestones [][2]string
+		opts       MilestoneOptions
+		expected   string
+	}{
+		{
+			name:       "after the highest closed milestone",
+			milestones: [][2]string{{"v1.0.0", "closed"}, {"v1.1.0", "closed"}},
+			opts:       MilestoneOptions{Bump: BumpMinor},
+			expected:   "v1.2.0",
+		},
+		{
+			name:     "first version",
+			opts:     MilestoneOptions{Bump: BumpMinor},
+			expected: "v0.1.0",
+		},
+		{
+			name:       "raised to the minimum",
+			milestones: [][2]string{{"v0.3.0", "closed"}},
+			opts:       MilestoneOptions{Bump: BumpMinor, Min: "v1.0.0"},
+			expected:   "v1.0.0",
+		},
+		{
+			name:       "past an excluded open milestone",
+			milestones: [][2]string{{"v1.1.0", "closed"}, {"v1.2.0", "open"}},
+			opts:       MilestoneOptions{Bump: BumpMinor, Exclude: []string{"v1.2.0"}},
+			expected:   "v1.3.0",
+		},
+		{
+			name:       "raised to the minimum and past an excluded open milestone",
+			milestones: [][2]string{{"v0.3.0", "closed"}, {" 1.0.0", "open"}},
+			opts:       MilestoneOptions{Bump: BumpPatch, Min: "v1.0.0", Exclude: []string{"1.0.0"}},
+			expected:   "v1.0.1",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			for i, m := range tc.milestones {
+				issues.addMilestone("owner", "repo", i+1, m[0], m[1])
+			}
+			tc.opts.Scheme = SemverScheme{}
+			tc.opts.Selection = SelectionLowest
+			tc.opts.Create = true
+
+			milestone, created, err := testPR.getMilestone(context.Background(), issues, tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if !created || milestone.GetTitle() != tc.expected {
+				t.Errorf("expected milestone %s to be created, got %q (created %t)", tc.expected, milestone.GetTitle(), created)
+			}
+		})
+	}
+}
+
+func TestUpdateMilestoneClosedSinceSelected(t *testing.T) {
+	issue := GitHubIssue{"owner", "repo", 12}
+	issues := newFakeIssues()
+	m := issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(issue, "closed", "")
+
+	selected := *m
+	m.State = github.String("closed")
+
+	change, err := issue.updateMilestone(context.Background(), issues, &selected, UpdateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if change != nil || len(issues.edits) > 0 {
+		t.Errorf("expected the issue to be skipped, got change %v and edits %v", change, issues.edits)
+	}
+}
+
+func TestGetMilestoneMaxOpen(t *testing.T) {
+	issues := newFakeIssues()
+	for i, title := range []string{"v1.0.0", "v1.1.0", "v1.2.0", "Backlog"} {
+		issues.addMilestone("owner", "repo", i+1, title, "open")
+	}
+
+	opts := MilestoneOptions{Selection: SelectionLowest, Scheme: SemverScheme{}, MaxOpen: 2}
+	if milestone, _, err := testPR.getMilestone(context.Background(), issues, opts); err == nil {
+		t.Errorf("expected too many open milestones to be refused, got %s", milestone.GetTitle())
+	}
+
+	opts.MaxOpen = 3
+	if _, _, err := testPR.getMilestone(context.Background(), issues, opts); err != nil {
+		t.Errorf("expected as many open milestones as the maximum to be allowed, got %+v", err)
+	}
+}
+
+func TestParseReferencedIssues(t *testing.T) {
+	body := "Fixes #1, part of #2 and relates to other/project#3. See #4. Part of ABC-5, relates to JIRA-123."
+	phrases := NewReferencePhrases("")
+
+	referenced := parseReferencedIssues(body, phrases, "github.com", "owner", "repo")
+	expected := []GitHubIssue{{"owner", "repo", 2}, {"other", "project", 3}}
+	if !reflect.DeepEqual(referenced, expected) {
+		t.Errorf("expected referenced issues %v, got %v", expected, referenced)
+	}
+
+	linked := parseLinkedIssues(body, DefaultKeywords, 0, "github.com", "owner", "repo")
+	expected = []GitHubIssue{{"owner", "repo", 1}}
+	if !reflect.DeepEqual(linked, expected) {
+		t.Errorf("expected closed issues %v, got %v", expected, linked)
+	}
+}
+
+func TestGetMilestoneExclude(t *testing.T) {
+	issues := newFakeIssues()
+	for i, title := range []string{"v1.0.0", "v1.1.0", "v1.2.0"} {
+		issues.addMilestone("owner", "repo", i+1, title, "open")
+	}
+
+	opts := MilestoneOptions{Selection: SelectionLowest, Scheme: SemverScheme{}, Exclude: []string{"v1.0.*"}}
+	milestone, _, err := testPR.getMilestone(context.Background(), issues, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if milestone.GetTitle() != "v1.1.0" {
+		t.Errorf("expected the lowest milestone that isn't excluded, v1.1.0, got %s", milestone.GetTitle())
+	}
+}
+
+func TestUpdateMilestoneReportsMismatch(t *testing.T) {
+	issues := newFakeIssues()
+	milestone := issues.addMilestone("owner", "repo", 2, "v1.1.0", "open")
+	issues.addMilestone("owner", "repo", 3, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "").Milestone = issues.milestone("owner", "repo", 3)
+	logged := captureLog(t)
+
+	change, err := testPR.updateMilestone(context.Background(), issues, milestone, UpdateOptions{IncludePullRequests: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if change != nil || len(issues.edits) > 0 {
+		t.Errorf("expected the milestone to be kept, got change %v and edits %v", change, issues.edits)
+	}
+	if !strings.Contains(logged.String(), "[WARN]") || !strings.Contains(logged.String(), "has milestone v1.0.0, not v1.1.0") {
+		t.Errorf("expected the mismatch to be warned about, got %q", logged.String())
+	}
+}
+
+func TestGetMilestoneReportsMissingMilestones(t *testing.T) {
+	cases := []struct {
+		name       string
+		milestones []string
+		expected   string
+	}{
+		{"no milestones", nil, "[DEBUG] owner/repo has no open milestones"},
+		{"no version milestones", []string{"Backlog"}, `[WARN] none of the 1 open milestones in owner/repo are version milestones, skipped 1 that don't match the version scheme, e.g. ["Backlog"]`},
+	}
+
+	ctx := WithLogger(context.Background(), Logger{Level: LogLevelDebug})
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			for i, title := range tc.milestones {
+				issues.addMilestone("owner", "repo", i+1, title, "open")
+			}
+			logged := captureLog(t)
+
+			milestone, _, err := testPR.getMilestone(ctx, issues, MilestoneOptions{Selection: SelectionLowest, Scheme: SemverScheme{}})
+			if err != nil || milestone != nil {
+				t.Fatalf("expected no milestone, got %v and error %+v", milestone, err)
+			}
+			if !strings.Contains(logged.String(), tc.expected) {
+				t.Errorf("expected %q to be logged, got %q", tc.expected, logged.String())
+			}
+		})
+	}
+}
+
+func TestUpdateMilestoneWithoutTitle(t *testing.T) {
+	issue := GitHubIssue{"owner", "repo", 12}
+
+	for _, number := range []int{2, 3} {
+		t.Run(fmt.Sprintf("milestone %d", number), func(t *testing.T) {
+			issues := newFakeIssues()
+			milestone := issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+			// partial responses may leave out the title
+			issues.addIssue(issue, "closed", "").Milestone = &github.Milestone{Number: github.Int(number)}
+			logged := captureLog(t)
+
+			change, err := issue.updateMilestone(context.Background(), issues, milestone, UpdateOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if change != nil || len(issues.edits) > 0 {
+				t.Errorf("expected the issue to be left alone, got change %v and edits %v", change, issues.edits)
+			}
+			if number == 3 && !strings.Contains(logged.String(), "has milestone number 3") {
+				t.Errorf("expected the milestone to be named by its number, got %q", logged.String())
+			}
+		})
+	}
+}
+
+func TestGetNamedMilestone(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     MilestoneOptions
+		expected int
+		err      bool
+	}{
+		{"by number", MilestoneOptions{Number: 3}, 3, false},
+		{"by title", MilestoneOptions{Title: "Backlog"}, 3, false},
+		{"missing number", MilestoneOptions{Number: 9}, 0, true},
+		{"missing title", MilestoneOptions{Title: "v9.0.0"}, 0, true},
+		{"closed", MilestoneOptions{Title: "v0.9.0"}, 0, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			issues.addMilestone("owner", "repo", 1, "v0.9.0", "closed")
+			issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+			issues.addMilestone("owner", "repo", 3, "Backlog", "open")
+
+			milestone, err := testPR.getNamedMilestone(context.Background(), issues, tc.opts)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected an error, got milestone %v", milestone)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if milestone.GetNumber() != tc.expected {
+				t.Errorf("expected milestone %d, got %d", tc.expected, milestone.GetNumber())
+			}
+		})
+	}
+}
+
+func TestCreateNextMilestoneDueDate(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 1, "v1.0.0", "closed")
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	opts := MilestoneOptions{Selection: SelectionLowest, Scheme: SemverScheme{}, Create: true, Bump: BumpPatch, DueInDays: 14}
+	milestone, _, err := testPR.getMilestone(context.Background(), issues, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	due := milestone.GetDueOn()
+	if expected := today.AddDate(0, 0, 14); !due.Equal(expected) && !due.Equal(expected.AddDate(0, 0, 1)) {
+		t.Errorf("expected the milestone to be due at the start of %s, got %s", expected.Format("2006-01-02"), due)
+	}
+	if due.Location() != time.UTC || due.Hour() != 0 || due.Minute() != 0 {
+		t.Errorf("expected a date without a time of day, got %s", due)
+	}
+}
+
+func TestUpdateMilestoneEmptyEditResponse(t *testing.T) {
+	issue := GitHubIssue{"owner", "repo", 12}
+	issues := newFakeIssues()
+	milestone := issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(issue, "closed", "")
+	issues.emptyEdits = true
+	logged := captureLog(t)
+
+	change, err := issue.updateMilestone(context.Background(), issues, milestone, UpdateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if change == nil {
+		t.Errorf("expected the issue to be linked")
+	}
+	if !strings.Contains(logged.String(), "[INFO] set milestone v1.0.0 (2) on owner/repo#12") {
+		t.Errorf("expected the milestone to be reported as set, got %q", logged.String())
+	}
+}
+
+func TestUpdateMilestonePullRequestReference(t *testing.T) {
+	issue := GitHubIssue{"owner", "repo", 12}
+
+	for _, include := range []bool{false, true} {
+		t.Run(fmt.Sprintf("include pull requests %t", include), func(t *testing.T) {
+			issues := newFakeIssues()
+			milestone := issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+			// the referenced number is another pull request
+			issues.addIssue(issue, "closed", "").PullRequestLinks = &github.PullRequestLinks{
+				URL: github.String("https://api.github.com/repos/owner/repo/pulls/12"),
+			}
+
+			change, err := issue.updateMilestone(context.Background(), issues, milestone, UpdateOptions{IncludePullRequests: include})
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if include && (change == nil || !reflect.DeepEqual(issues.edits, []fakeEdit{{issue, 2}})) {
+				t.Errorf("expected the pull request to be linked, got change %v and edits %v", change, issues.edits)
+			}
+			if !include && (change != nil || len(issues.edits) > 0) {
+				t.Errorf("expected the pull request to be skipped, got change %v and edits %v", change, issues.edits)
+			}
+		})
+	}
+}
+
+func TestGetMilestoneDueDateTieBreak(t *testing.T) {
+	due := func(day int) *time.Time {
+		d := time.Date(2021, time.March, day, 0, 0, 0, 0, time.UTC)
+		return &d
+	}
+
+	cases := []struct {
+		name     string
+		first    *time.Time
+		second   *time.Time
+		expected int
+	}{
+		{"earlier listed first", due(1), due(15), 2},
+		{"earlier listed last", due(15), due(1), 3},
+		{"without due date", nil, due(15), 3},
+		{"same due date", due(1), due(1), 2},
+		{"neither due", nil, nil, 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			issues.addMilestone("owner", "repo", 2, "v1.0.0", "open").DueOn = tc.first
+			issues.addMilestone("owner", "repo", 3, "v1.0.0", "open").DueOn = tc.second
+			issues.addMilestone("owner", "repo", 4, "v1.1.0", "open")
+
+			milestone, _, err := testPR.getMilestone(context.Background(), issues, MilestoneOptions{Selection: SelectionLowest, Scheme: SemverScheme{}})
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if milestone.GetNumber() != tc.expected {
+				t.Errorf("expected milestone %d, got %q (%d)", tc.expected, milestone.GetTitle(), milestone.GetNumber())
+			}
+		})
+	}
+}
+
+func TestGetMilestoneClosest(t *testing.T) {
+	cases := []struct {
+		name      string
+		reference string
+		titles    []string
+		expected  string
+	}{
+		{"closer minor over next major", "v1.2.5", []string{"v2.0.0", "v1.3.0", "v1.0.0"}, "v1.3.0"},
+		{"closer patch", "v1.2.5", []string{"v1.3.0", "v1.2.6"}, "v1.2.6"},
+		{"same version", "v1.2.5", []string{"v1.2.4", "v1.2.5", "v1.2.6"}, "v1.2.5"},
+		{"as close below and above", "v1.2.5", []string{"v1.1.0", "v1.3.0"}, "v1.3.0"},
+		{"major before minor", "v2.0.0", []string{"v1.9.0", "v3.0.0"}, "v3.0.0"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			for i, title := range tc.titles {
+				issues.addMilestone("owner", "repo", i+1, title, "open")
+			}
+
+			opts := MilestoneOptions{Selection: SelectionClosest, Reference: tc.reference, Scheme: SemverScheme{}}
+			milestone, _, err := testPR.getMilestone(context.Background(), issues, opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if milestone.GetTitle() != tc.expected {
+				t.Errorf("expected milestone %s, got %s", tc.expected, milestone.GetTitle())
+			}
+		})
+	}
+}
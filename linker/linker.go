@@ -0,0 +1,664 @@
+// Package linker links merged pull requests, and the issues they close, to a version milestone. It is the core of
+// the link-milestone command, which configures it from the environment.
+package linker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/google/go-github/github"
+)
+
+// ErrNoMilestone is returned by Link when no milestone was found for a pull request and Options.RequireMilestone is
+// set.
+var ErrNoMilestone = errors.New("no open version milestone to link to, one needs to be created")
+
+// Options configure how pull requests and their issues are linked.
+type Options struct {
+	// Mode is ModeLink to assign the milestone, or ModeUnlink to remove it again, e.g. after a revert.
+	Mode string
+
+	Milestone MilestoneOptions
+	Update    UpdateOptions
+
+	// SkipPR only links the issues, leaving the pull request's own milestone alone.
+	SkipPR bool
+	// LinkOnOpen links pull requests that are still open, so they show up on the milestone before being merged. Their
+	// issues are only linked once closed, unless they are referenced ones.
+	LinkOnOpen bool
+	// CloseCompleted closes the milestone once linking leaves it without open issues.
+	CloseCompleted bool
+	// Concurrency is the maximum number of linked issues updated at once.
+	Concurrency int
+
+	// LinkMode is how the issues closed by a pull request are found, either LinkModeRegex or LinkModeGraphQL.
+	LinkMode string
+	// Keywords matches the closing keywords when LinkMode is LinkModeRegex, DefaultKeywords when nil.
+	Keywords *regexp.Regexp
+	// KeywordGap is how many other words may come between a closing keyword and the first issue it references, e.g. 2
+	// for "fixes the issue #12". Zero requires the issue to follow the keyword.
+	KeywordGap int
+	// ScanCommits also looks for closing keywords in the messages of the pull request's commits.
+	ScanCommits bool
+	// LabelFallback, when no issue is closed by the pull request, links the recently updated issues without a
+	// milestone that carry a label mapped to the milestone by Milestone.LabelMilestones. This is best-effort: only
+	// issues updated within labelFallbackWindow are considered.
+	LabelFallback bool
+	// TimelineFallback, when no issue is found otherwise, links the issues that cross-reference the pull request in
+	// its timeline and are cross-referenced by it in turn, e.g. when the token can't use the GraphQL API.
+	TimelineFallback bool
+	// LinkRepos, when set, are the repositories, as owner/repo, that references to the pull request's own repository,
+	// such as a bare #123, are looked up in, in order. The issue is linked in the first one it exists in.
+	LinkRepos []string
+	// ProjectColumn, when set, only links the issues with this Status in a project, or on GitHub Enterprise Server in
+	// this column of a classic project board.
+	ProjectColumn *ProjectColumn
+	// References, when set, are the non-closing phrases such as "part of" whose issues are linked too, even if open.
+	References [][]string
+	// Comment, when set, renders a comment posted on the pull request after linking it.
+	Comment *template.Template
+	// RequireLabel, when set, skips pull requests without this label, compared case-insensitively as GitHub does.
+	RequireLabel string
+	// ExcludeAuthors are the logins of authors whose pull requests are skipped, e.g. dependabot[bot].
+	ExcludeAuthors []string
+	// RequireMilestone makes Link fail with ErrNoMilestone when there is no milestone to link to, instead of skipping
+	// the pull request.
+	RequireMilestone bool
+
+	// Logger writes the lines logged while linking.
+	Logger Logger
+	// MaxRetries, when set, is the number of times a GitHub API call is retried after hitting a rate limit, instead of
+	// DefaultMaxRetries.
+	MaxRetries *int
+}
+
+// Linker links merged pull requests, and the issues they close, to a milestone. The milestones it lists are cached,
+// so a Linker should be reused for the pull requests of a single run.
+type Linker struct {
+	client *github.Client
+	issues issuesService
+	opts   Options
+	// host is where issues referenced by URL are, github.com or the GitHub Enterprise Server.
+	host string
+}
+
+// New returns a Linker calling the GitHub API through client.
+func New(client *github.Client, opts Options) *Linker {
+	return newLinker(client, newIssueCache(newMilestoneCache(issuesClient{client.Issues, client})), opts)
+}
+
+// newLinker returns a Linker calling the issues API through issues and the rest of the GitHub API through client, so
+// tests can substitute the issues API.
+func newLinker(client *github.Client, issues issuesService, opts Options) *Linker {
+	if opts.Mode == "" {
+		opts.Mode = ModeLink
+	}
+	if opts.LinkMode == "" {
+		opts.LinkMode = LinkModeRegex
+	}
+	if opts.Keywords == nil {
+		opts.Keywords = DefaultKeywords
+	}
+	if opts.Milestone.Scheme == nil {
+		opts.Milestone.Scheme = SemverScheme{}
+	}
+
+	return &Linker{
+		client: client,
+		issues: issues,
+		opts:   opts,
+		host:   webHost(client),
+	}
+}
+
+// withSettings returns a copy of ctx that logs with Options.Logger and retries GitHub API calls up to
+// Options.MaxRetries times, so that Linkers with different settings can run side by side.
+func (l *Linker) withSettings(ctx context.Context) context.Context {
+	ctx = WithLogger(ctx, l.opts.Logger)
+	if l.opts.MaxRetries != nil {
+		ctx = WithMaxRetries(ctx, *l.opts.MaxRetries)
+	}
+	return ctx
+}
+
+// Link links the pull request pr using a new Linker, see Linker.Link.
+func Link(ctx context.Context, client *github.Client, pr GitHubIssue, opts Options) (*Result, error) {
+	return New(client, opts).Link(ctx, pr)
+}
+
+// commentData is passed to the comment template.
+type commentData struct {
+	// Milestone is the title of the milestone the pull request was linked to.
+	Milestone string
+	// Issues lists the issues closed by the pull request, e.g. "#12, other/repo#45".
+	Issues string
+}
+
+// DefaultCommentTemplate is used for the comment when no template is configured.
+const DefaultCommentTemplate = `This pull request was linked to milestone {{.Milestone}}.{{if .Issues}} The issues it closes were linked too: {{.Issues}}{{end}}`
+
+// Result describes what was linked for a pull request.
+type Result struct {
+	Milestone *github.Milestone
+	// CreatedMilestone is set when Milestone was created because no open version milestone existed.
+	CreatedMilestone bool
+	LinkedIssues     []GitHubIssue
+	// ReferencedIssues are the issues linked without being closed by the pull request, either because they are
+	// referenced with a non-closing keyword or found by Options.LabelFallback.
+	ReferencedIssues []GitHubIssue
+	// Changes are the milestones set on, or removed from, the pull request and its issues, or that would have been
+	// in a dry run.
+	Changes []Change
+}
+
+// Link assigns a milestone to the merged pull request pr, or the open one with Options.LinkOnOpen, and the issues it
+// closes. In ModeUnlink the milestone is removed from them instead, whether or not pr is merged. Draft pull requests,
+// those without Options.RequireLabel and those by Options.ExcludeAuthors, are skipped, which is signalled by a nil
+// result.
+func (l *Linker) Link(ctx context.Context, pr GitHubIssue) (*Result, error) {
+	ctx = withLogScope(l.withSettings(ctx), pr)
+
+	unlink := l.opts.Mode == ModeUnlink
+	pullRequest, err := l.getPullRequest(ctx, pr)
+	if err != nil {
+		return nil, err
+	}
+	for _, author := range l.opts.ExcludeAuthors {
+		if login := pullRequest.GetUser().GetLogin(); strings.EqualFold(login, author) {
+			loggerFrom(ctx).Infof(LogFields{Issue: pr.String()}, "pull request #%d is by %s, skipping", pr.Id, login)
+			return nil, nil
+		}
+	}
+	if l.opts.RequireLabel != "" && !pullRequest.hasLabel(l.opts.RequireLabel) {
+		loggerFrom(ctx).Infof(LogFields{Issue: pr.String()}, "pull request #%d isn't labelled %s, skipping", pr.Id, l.opts.RequireLabel)
+		return nil, nil
+	}
+	if !unlink {
+		if pullRequest.Draft {
+			loggerFrom(ctx).Infof(LogFields{Issue: pr.String()}, "pull request #%d is a draft, skipping", pr.Id)
+			return nil, nil
+		}
+		if !pullRequest.GetMerged() && !(l.opts.LinkOnOpen && pullRequest.GetState() == "open") {
+			loggerFrom(ctx).Infof(LogFields{Issue: pr.String()}, "pull request #%d was closed without being merged, skipping", pr.Id)
+			return nil, nil
+		}
+	}
+
+	// there is nothing to unlink from a milestone that has yet to be created
+	opts := l.opts.Milestone
+	opts.Create = opts.Create && !unlink
+
+	// a milestone named in the options is used as is, otherwise one mapped to one of the pull request's labels takes
+	// precedence over the selected version milestone
+	var milestone *github.Milestone
+	if l.opts.Milestone.Number != 0 || l.opts.Milestone.Title != "" {
+		if milestone, err = pr.getNamedMilestone(ctx, l.issues, l.opts.Milestone); err != nil {
+			return nil, fmt.Errorf("getting milestone: %s", err)
+		}
+	}
+	if milestone == nil && len(l.opts.Milestone.LabelMilestones) > 0 {
+		if milestone, err = pr.getLabelMilestone(ctx, l.issues, l.opts.Milestone.LabelMilestones); err != nil {
+			return nil, fmt.Errorf("getting milestone: %s", err)
+		}
+	}
+	// followed by the milestone of the release branch the pull request was merged into
+	if milestone == nil && l.opts.Milestone.BranchPattern != nil {
+		if milestone, err = pr.getBranchMilestone(ctx, l.issues, pullRequest.GetBase().GetRef(), l.opts.Milestone); err != nil {
+			return nil, fmt.Errorf("getting milestone: %s", err)
+		}
+	}
+	// and the maintenance milestone of a backport
+	if milestone == nil && l.opts.Milestone.BackportScheme != nil && pullRequest.isBackport(l.opts.Milestone.BackportLabel) {
+		if milestone, err = pr.getBackportMilestone(ctx, l.issues, l.opts.Milestone); err != nil {
+			return nil, fmt.Errorf("getting milestone: %s", err)
+		}
+	}
+	// and the milestone of the version released by the pull request, going by its title
+	if milestone == nil && l.opts.Milestone.TitleVersionPattern != nil {
+		if version := titleVersion(l.opts.Milestone.TitleVersionPattern, pullRequest.GetTitle()); version != "" {
+			titleOpts := l.opts.Milestone
+			titleOpts.Version = version
+			if milestone, err = pr.getVersionMilestone(ctx, l.issues, titleOpts); err != nil {
+				return nil, fmt.Errorf("getting milestone: %s", err)
+			}
+		}
+	}
+	// or the version file
+	if milestone == nil && l.opts.Milestone.Version != "" {
+		if milestone, err = pr.getVersionMilestone(ctx, l.issues, l.opts.Milestone); err != nil {
+			return nil, fmt.Errorf("getting milestone: %s", err)
+		}
+	}
+	created := false
+	if milestone == nil {
+		if milestone, created, err = pr.getMilestone(ctx, l.issues, opts); err != nil {
+			return nil, fmt.Errorf("getting milestone: %s", err)
+		}
+	}
+	if milestone == nil && l.opts.RequireMilestone {
+		return nil, ErrNoMilestone
+	}
+	if milestone == nil {
+		loggerFrom(ctx).Infof(LogFields{Issue: pr.String()}, "no open version milestones exists in github")
+		return nil, nil
+	}
+
+	loggerFrom(ctx).Infof(LogFields{Issue: pr.String(), Milestone: milestone.GetTitle()}, "%sing milestone %s (%d)", l.opts.Mode, milestone.GetTitle(), milestone.GetNumber())
+
+	apply := func(u issueUpdate) (*Change, error) {
+		if unlink {
+			return u.Issue.removeMilestone(ctx, l.issues, u.Milestone, l.opts.Update.DryRun)
+		}
+		return u.Issue.updateMilestone(ctx, l.issues, u.Milestone, u.Opts)
+	}
+
+	var changes []Change
+	if l.opts.SkipPR {
+		loggerFrom(ctx).Infof(LogFields{Issue: pr.String()}, "leaving the milestone of pull request #%d alone", pr.Id)
+	} else {
+		prOpts := l.opts.Update
+		prOpts.IncludePullRequests = true
+		// with LinkOnOpen the pull request is linked before it is merged, and so closed
+		prOpts.IncludeOpen = prOpts.IncludeOpen || l.opts.LinkOnOpen
+		change, err := apply(issueUpdate{pr, milestone, prOpts})
+		if err != nil {
+			return nil, err
+		}
+		if change != nil {
+			changes = append(changes, *change)
+		}
+	}
+
+	var linkedIssues []GitHubIssue
+	if l.opts.LinkMode == LinkModeGraphQL {
+		linkedIssues, err = pr.getClosingIssues(ctx, l.client)
+	} else {
+		linkedIssues, err = pr.getLinkedIssue(ctx, l.issues, l.opts.Keywords, l.opts.KeywordGap, l.host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting linked issues for #%d: %+v", pr.Id, err)
+	}
+	if l.opts.ScanCommits {
+		fromCommits, err := pr.getCommitLinkedIssues(ctx, l.client, l.opts.Keywords, l.opts.KeywordGap, l.host)
+		if err != nil {
+			return nil, err
+		}
+
+		seen := make(map[GitHubIssue]bool)
+		for _, li := range linkedIssues {
+			seen[li] = true
+		}
+		for _, li := range fromCommits {
+			if !seen[li] {
+				seen[li] = true
+				linkedIssues = append(linkedIssues, li)
+			}
+		}
+	}
+
+	if linkedIssues, err = l.resolveRepos(ctx, pr, linkedIssues); err != nil {
+		return nil, err
+	}
+
+	if l.opts.TimelineFallback && len(linkedIssues) == 0 {
+		if linkedIssues, err = pr.getTimelineLinkedIssues(ctx, l.issues); err != nil {
+			return nil, err
+		}
+	}
+
+	targets := make([]issueUpdate, len(linkedIssues))
+	listed := make(map[GitHubIssue]bool)
+	for i, li := range linkedIssues {
+		targets[i] = issueUpdate{li, nil, l.opts.Update}
+		listed[li] = true
+	}
+
+	// issues linked without being closed by the pull request may still be open, so they are linked regardless of
+	// their state
+	openOpts := l.opts.Update
+	openOpts.IncludeOpen = true
+
+	var referencedIssues []GitHubIssue
+	if len(l.opts.References) > 0 {
+		referenced, err := pr.getReferencedIssues(ctx, l.issues, l.opts.References, l.host)
+		if err != nil {
+			return nil, fmt.Errorf("getting referenced issues for #%d: %+v", pr.Id, err)
+		}
+		if referenced, err = l.resolveRepos(ctx, pr, referenced); err != nil {
+			return nil, err
+		}
+		for _, ri := range referenced {
+			if !listed[ri] {
+				listed[ri] = true
+				referencedIssues = append(referencedIssues, ri)
+				targets = append(targets, issueUpdate{ri, nil, openOpts})
+			}
+		}
+	}
+
+	if l.opts.LabelFallback && len(linkedIssues) == 0 {
+		labelled, err := pr.getLabelledIssues(ctx, l.issues, milestoneLabels(l.opts.Milestone.LabelMilestones, milestone.GetTitle()))
+		if err != nil {
+			return nil, err
+		}
+		for _, li := range labelled {
+			if !listed[li] {
+				listed[li] = true
+				referencedIssues = append(referencedIssues, li)
+				targets = append(targets, issueUpdate{li, nil, openOpts})
+			}
+		}
+	}
+
+	if l.opts.ProjectColumn != nil {
+		inColumn, err := pr.getProjectColumnIssues(ctx, l.client, *l.opts.ProjectColumn)
+		if err != nil {
+			return nil, err
+		}
+
+		var kept []issueUpdate
+		for _, t := range targets {
+			if inColumn[t.Issue] {
+				kept = append(kept, t)
+			} else {
+				loggerFrom(ctx).Debugf(LogFields{Issue: t.Issue.String()}, "%s isn't in project column %s, skipping", t.Issue, l.opts.ProjectColumn)
+			}
+		}
+		targets = kept
+		linkedIssues = filterIssues(linkedIssues, inColumn)
+		referencedIssues = filterIssues(referencedIssues, inColumn)
+	}
+
+	// milestone numbers are scoped to a repository, so issues in other repositories are linked to the open milestone
+	// with the same title there or, when there is none, to the version milestone selected in that repository, which is
+	// never created
+	foreignOpts := opts
+	foreignOpts.Create = false
+	var updates []issueUpdate
+	repoMilestones := map[string]*github.Milestone{pr.Owner + "/" + pr.Repo: milestone}
+	for _, t := range targets {
+		li := t.Issue
+		repoName := li.Owner + "/" + li.Repo
+		m, ok := repoMilestones[repoName]
+		if li.Owner != pr.Owner || li.Repo != pr.Repo {
+			// the pull request may reference issues in repositories the token can't read, which are left alone rather
+			// than failing the pull request that was already linked
+			_, resp, err := li.get(ctx, l.issues)
+			if noAccess(resp, err) {
+				loggerFrom(ctx).Warnf(LogFields{Issue: li.String()}, "can't read %s, the token may lack access to %s: skipping it", li, repoName)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			t.Opts.SkipNoAccess = true
+		}
+		if !ok {
+			if m, err = li.findMilestone(ctx, l.issues, milestone.GetTitle()); err != nil {
+				return nil, err
+			}
+			if m == nil {
+				if m, _, err = li.getMilestone(ctx, l.issues, foreignOpts); err != nil {
+					return nil, fmt.Errorf("getting milestone in %s: %s", repoName, err)
+				}
+				if m != nil {
+					loggerFrom(ctx).Infof(LogFields{Issue: li.String(), Milestone: m.GetTitle()}, "%s has no open milestone %s, using its own milestone %s", repoName, milestone.GetTitle(), m.GetTitle())
+				}
+			}
+			repoMilestones[repoName] = m
+		}
+		if m == nil {
+			loggerFrom(ctx).Debugf(LogFields{Issue: li.String(), Milestone: milestone.GetTitle()}, "%s has no open milestone %s or version milestone, skipping issue #%d", repoName, milestone.GetTitle(), li.Id)
+			continue
+		}
+
+		t.Milestone = m
+		updates = append(updates, t)
+	}
+
+	issueChanges, err := l.applyAll(updates, apply)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, issueChanges...)
+
+	if unlink {
+		return &Result{Milestone: milestone, CreatedMilestone: created, LinkedIssues: linkedIssues, ReferencedIssues: referencedIssues, Changes: changes}, nil
+	}
+
+	if l.opts.CloseCompleted {
+		if err = pr.closeMilestoneIfCompleted(ctx, l.issues, milestone, l.opts.Update.DryRun); err != nil {
+			return nil, err
+		}
+	}
+
+	// a re-run that changes nothing has already commented
+	if l.opts.Comment != nil && !l.opts.Update.DryRun && len(changes) == 0 {
+		loggerFrom(ctx).Debugf(LogFields{Issue: pr.String()}, "no milestones were changed, not commenting on pull request #%d again", pr.Id)
+	} else if l.opts.Comment != nil && !l.opts.Update.DryRun {
+		if err = l.postComment(ctx, pr, milestone, linkedIssues); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Result{Milestone: milestone, CreatedMilestone: created, LinkedIssues: linkedIssues, ReferencedIssues: referencedIssues, Changes: changes}, nil
+}
+
+// resolveRepos moves the issues in the pull request's repository to the first of Options.LinkRepos they exist in,
+// leaving them where they are when they exist in none. Issues that end up listed twice are only returned once.
+func (l *Linker) resolveRepos(ctx context.Context, pr GitHubIssue, issues []GitHubIssue) ([]GitHubIssue, error) {
+	if len(l.opts.LinkRepos) == 0 {
+		return issues, nil
+	}
+
+	var resolved []GitHubIssue
+	seen := make(map[GitHubIssue]bool)
+	for _, li := range issues {
+		if li.Owner == pr.Owner && li.Repo == pr.Repo {
+			for _, r := range l.opts.LinkRepos {
+				parts := strings.SplitN(r, "/", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				candidate := GitHubIssue{parts[0], parts[1], li.Id}
+
+				_, resp, err := candidate.get(ctx, l.issues)
+				if resp != nil && resp.Response != nil && resp.StatusCode == http.StatusNotFound {
+					continue
+				}
+				if err != nil {
+					return nil, fmt.Errorf("looking up issue #%d in %s: %+v", li.Id, r, err)
+				}
+
+				loggerFrom(ctx).Infof(LogFields{Issue: candidate.String()}, "issue #%d resolved to %s", li.Id, candidate)
+				li = candidate
+				break
+			}
+		}
+
+		if !seen[li] {
+			seen[li] = true
+			resolved = append(resolved, li)
+		}
+	}
+	return resolved, nil
+}
+
+// filterIssues returns the issues found in keep.
+func filterIssues(issues []GitHubIssue, keep map[GitHubIssue]bool) []GitHubIssue {
+	var kept []GitHubIssue
+	for _, i := range issues {
+		if keep[i] {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+// CheckResult is what Check found in a repository.
+type CheckResult struct {
+	// Milestones are the open version milestones, from the lowest to the highest version.
+	Milestones []*github.Milestone
+	// Selected is the milestone Link would use for pull requests without a named, labelled or branch milestone, nil
+	// when there is none.
+	Selected *github.Milestone
+}
+
+// Check reports the open version milestones of owner/repo and which of them would be selected, without changing
+// anything: a missing milestone isn't created.
+func (l *Linker) Check(ctx context.Context, owner string, repo string) (*CheckResult, error) {
+	ctx = l.withSettings(ctx)
+	r := GitHubIssue{Owner: owner, Repo: repo}
+	ghMilestones, err := r.listMilestones(ctx, l.issues, "open")
+	if err != nil {
+		return nil, err
+	}
+
+	opts := l.opts.Milestone
+	result := &CheckResult{}
+	for _, m := range ghMilestones {
+		if _, ok := excluded(opts.Exclude, strings.TrimSpace(m.GetTitle())); ok || !opts.Scheme.Match(NormalizeTitle(opts.Scheme, m.GetTitle())) {
+			continue
+		}
+		result.Milestones = append(result.Milestones, m)
+	}
+	sort.SliceStable(result.Milestones, func(i, j int) bool {
+		a, b := result.Milestones[i], result.Milestones[j]
+		return opts.Scheme.Compare(NormalizeTitle(opts.Scheme, a.GetTitle()), NormalizeTitle(opts.Scheme, b.GetTitle())) < 0
+	})
+
+	opts.Create = false
+	if result.Selected, _, err = r.getMilestone(ctx, l.issues, opts); err != nil {
+		return nil, fmt.Errorf("getting milestone: %s", err)
+	}
+	return result, nil
+}
+
+// pullRequest is a github.PullRequest along with whether it is a draft and its labels, which go-github doesn't decode.
+type pullRequest struct {
+	*github.PullRequest
+	Draft  bool            `json:"draft"`
+	Labels []*github.Label `json:"labels"`
+}
+
+// hasLabel reports whether the pull request is labelled name.
+func (p *pullRequest) hasLabel(name string) bool {
+	for _, label := range p.Labels {
+		if strings.EqualFold(label.GetName(), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBackport reports whether the pull request is a backport, either labelled label or titled e.g. "[Backport 1.2] ...".
+func (p *pullRequest) isBackport(label string) bool {
+	if label != "" && p.hasLabel(label) {
+		return true
+	}
+	title := strings.TrimLeft(p.GetTitle(), openingPunctuation+" ")
+	return strings.HasPrefix(strings.ToLower(title), "backport")
+}
+
+// getPullRequest fetches the pull request pr.
+func (l *Linker) getPullRequest(ctx context.Context, pr GitHubIssue) (*pullRequest, error) {
+	var pull pullRequest
+	err := WithRetry(ctx, func() error {
+		req, err := l.client.NewRequest("GET", fmt.Sprintf("repos/%v/%v/pulls/%d", pr.Owner, pr.Repo, pr.Id), nil)
+		if err != nil {
+			return err
+		}
+		res, err := l.client.Do(ctx, req, &pull)
+		LogRate(ctx, res)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting pull request #%d: %+v", pr.Id, err)
+	}
+	return &pull, nil
+}
+
+// issueUpdate is a linked issue, the milestone of its repository and how to update it.
+type issueUpdate struct {
+	Issue     GitHubIssue
+	Milestone *github.Milestone
+	Opts      UpdateOptions
+}
+
+// applyAll calls apply for each update, running up to Options.Concurrency at once, and returns the changes in the order
+// of the updates. All updates are attempted, the error returned is that of the first failing update in order so it
+// doesn't depend on scheduling.
+func (l *Linker) applyAll(updates []issueUpdate, apply func(issueUpdate) (*Change, error)) ([]Change, error) {
+	workers := l.opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	errs := make([]error, len(updates))
+	changes := make([]*Change, len(updates))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, u := range updates {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, u issueUpdate) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			changes[i], errs[i] = apply(u)
+		}(i, u)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var applied []Change
+	for _, c := range changes {
+		if c != nil {
+			applied = append(applied, *c)
+		}
+	}
+	return applied, nil
+}
+
+// postComment comments on the pull request which milestone it and its linked issues were linked to.
+func (l *Linker) postComment(ctx context.Context, pr GitHubIssue, milestone *github.Milestone, linkedIssues []GitHubIssue) error {
+	refs := make([]string, len(linkedIssues))
+	for i, li := range linkedIssues {
+		refs[i] = fmt.Sprintf("#%d", li.Id)
+		if li.Owner != pr.Owner || li.Repo != pr.Repo {
+			refs[i] = li.String()
+		}
+	}
+
+	var body bytes.Buffer
+	err := l.opts.Comment.Execute(&body, commentData{
+		Milestone: milestone.GetTitle(),
+		Issues:    strings.Join(refs, ", "),
+	})
+	if err != nil {
+		return fmt.Errorf("rendering comment for #%d: %+v", pr.Id, err)
+	}
+
+	comment := body.String()
+	err = WithRetry(ctx, func() error {
+		_, resp, err := l.issues.CreateComment(ctx, pr.Owner, pr.Repo, pr.Id, &github.IssueComment{Body: &comment})
+		LogRate(ctx, resp)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("commenting on #%d: %+v", pr.Id, err)
+	}
+	return nil
+}
@@ -0,0 +1,567 @@
+package linker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// mergedPR is testPR as returned by the pull requests API once merged.
+const mergedPR = `{"number": 1, "merged": true, "state": "closed", "title": "Add a feature", "base": {"ref": "main"}}`
+
+// newTestClient returns a client for a test server answering the requests in routes, keyed by method and path, e.g.
+// "GET /repos/owner/repo/pulls/1", with their JSON body. Any other request is answered with a 404.
+func newTestClient(t *testing.T, routes map[string]string) *github.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body, ok := routes[r.Method+" "+r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "Not Found"}`)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	return client
+}
+
+// newTestLinker returns a Linker using issues, and a test server serving pr as testPR along with routes.
+func newTestLinker(t *testing.T, issues *fakeIssues, pr string, routes map[string]string, opts Options) *Linker {
+	if routes == nil {
+		routes = make(map[string]string)
+	}
+	routes["GET /repos/owner/repo/pulls/1"] = pr
+
+	return newLinker(newTestClient(t, routes), issues, opts)
+}
+
+func TestLinkReportsIssueErrors(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "Fixes #12")
+	issues.errs["Get"] = errors.New("boom")
+
+	result, err := newTestLinker(t, issues, mergedPR, nil, Options{}).Link(context.Background(), testPR)
+	if err == nil {
+		t.Fatalf("expected an error, got %+v", result)
+	}
+	if !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected the error to say boom, got %+v", err)
+	}
+}
+
+func TestLinkSkipsUnmergedPullRequests(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "Fixes #12")
+	issues.addIssue(GitHubIssue{"owner", "repo", 12}, "closed", "")
+
+	pr := `{"number": 1, "merged": false, "state": "closed", "base": {"ref": "main"}}`
+	result, err := newTestLinker(t, issues, pr, nil, Options{}).Link(context.Background(), testPR)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if result != nil {
+		t.Errorf("expected the pull request to be skipped, got %+v", result)
+	}
+	if len(issues.edits) > 0 {
+		t.Errorf("expected nothing to be edited, got %v", issues.edits)
+	}
+}
+
+func TestLinkOnOpen(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "open", "Fixes #12, #13")
+	issues.addIssue(GitHubIssue{"owner", "repo", 12}, "closed", "")
+	issues.addIssue(GitHubIssue{"owner", "repo", 13}, "open", "")
+
+	pr := `{"number": 1, "merged": false, "state": "open", "base": {"ref": "main"}}`
+	_, err := newTestLinker(t, issues, pr, nil, Options{LinkOnOpen: true}).Link(context.Background(), testPR)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	// the issues are still only linked once closed
+	expected := []fakeEdit{{testPR, 2}, {GitHubIssue{"owner", "repo", 12}, 2}}
+	if !reflect.DeepEqual(issues.edits, expected) {
+		t.Errorf("expected edits %v, got %v", expected, issues.edits)
+	}
+}
+
+func TestLinkSkipsInaccessibleRepositories(t *testing.T) {
+	cases := []struct {
+		name string
+		errs map[string]error
+	}{
+		{
+			name: "issue not found",
+			errs: map[string]error{"Get": statusError(http.StatusNotFound)},
+		},
+		{
+			name: "edit forbidden",
+			errs: map[string]error{"Edit": statusError(http.StatusForbidden)},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+			issues.addMilestone("other", "project", 5, "v1.0.0", "open")
+			issues.addIssue(testPR, "closed", "Fixes other/project#34")
+			issues.addIssue(GitHubIssue{"other", "project", 34}, "closed", "")
+
+			for method, err := range tc.errs {
+				issues.errs[method+" other/project#34"] = err
+			}
+
+			result, err := newTestLinker(t, issues, mergedPR, nil, Options{}).Link(context.Background(), testPR)
+			if err != nil {
+				t.Fatalf("expected the issue to be skipped, got error %+v", err)
+			}
+			if len(result.Changes) != 1 || result.Changes[0].Issue != testPR {
+				t.Errorf("expected only the pull request to change, got %+v", result.Changes)
+			}
+		})
+	}
+}
+
+func TestLinkStopsWhenCancelled(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := newTestLinker(t, issues, mergedPR, nil, Options{}).Link(ctx, testPR)
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("expected the link to be cancelled, got %+v and error %+v", result, err)
+	}
+	if len(issues.edits) > 0 {
+		t.Errorf("expected nothing to be edited, got %v", issues.edits)
+	}
+}
+
+func TestLinkUpdatesIssuesConcurrently(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	var refs []string
+	for n := 10; n < 30; n++ {
+		issues.addIssue(GitHubIssue{"owner", "repo", n}, "closed", "")
+		refs = append(refs, fmt.Sprintf("fixes #%d", n))
+	}
+	issues.addIssue(testPR, "closed", strings.Join(refs, ", "))
+	issues.errs["Edit owner/repo#15"] = errors.New("boom")
+
+	done := make(chan error)
+	go func() {
+		_, err := newTestLinker(t, issues, mergedPR, nil, Options{Concurrency: 4}).Link(context.Background(), testPR)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "boom") {
+			t.Errorf("expected the failed issue to be reported, got %+v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("linking didn't finish")
+	}
+	// the pull request and every issue, including the one failing
+	if issues.calls["Edit"] != 21 {
+		t.Errorf("expected 21 edits, got %d", issues.calls["Edit"])
+	}
+}
+
+func TestLinkSkipsDraftPullRequests(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "open", "Fixes #12")
+	issues.addIssue(GitHubIssue{"owner", "repo", 12}, "closed", "")
+
+	pr := `{"number": 1, "draft": true, "merged": false, "state": "open", "base": {"ref": "main"}}`
+	result, err := newTestLinker(t, issues, pr, nil, Options{LinkOnOpen: true}).Link(context.Background(), testPR)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if result != nil || len(issues.edits) > 0 {
+		t.Errorf("expected the draft to be skipped, got %+v and edits %v", result, issues.edits)
+	}
+}
+
+func TestLinkBranchMilestone(t *testing.T) {
+	cases := []struct {
+		branch   string
+		expected int
+	}{
+		{"release/1.2", 3},
+		{"main", 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.branch, func(t *testing.T) {
+			issues := newFakeIssues()
+			issues.addMilestone("owner", "repo", 2, "v1.3.0", "open")
+			issues.addMilestone("owner", "repo", 3, "v1.2.x", "open")
+			issues.addIssue(testPR, "closed", "")
+
+			pr := fmt.Sprintf(`{"number": 1, "merged": true, "state": "closed", "base": {"ref": %q}}`, tc.branch)
+			opts := Options{Milestone: MilestoneOptions{BranchPattern: regexp.MustCompile(`^release/(\d+\.\d+)$`), BranchTitle: "v$1.x"}}
+			if _, err := newTestLinker(t, issues, pr, nil, opts).Link(context.Background(), testPR); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			expected := []fakeEdit{{testPR, tc.expected}}
+			if !reflect.DeepEqual(issues.edits, expected) {
+				t.Errorf("expected edits %v, got %v", expected, issues.edits)
+			}
+		})
+	}
+}
+
+func TestLinkScansCommits(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "Fixes #12")
+	issues.addIssue(GitHubIssue{"owner", "repo", 12}, "closed", "")
+	issues.addIssue(GitHubIssue{"owner", "repo", 42}, "closed", "")
+
+	routes := map[string]string{
+		"GET /repos/owner/repo/pulls/1/commits": `[{"sha": "abc", "commit": {"message": "Handle empty bodies\n\nFixes #42"}}, {"sha": "def", "commit": {"message": "Fixes #12"}}]`,
+	}
+	result, err := newTestLinker(t, issues, mergedPR, routes, Options{ScanCommits: true}).Link(context.Background(), testPR)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := []GitHubIssue{{"owner", "repo", 12}, {"owner", "repo", 42}}
+	if !reflect.DeepEqual(result.LinkedIssues, expected) {
+		t.Errorf("expected linked issues %v, got %v", expected, result.LinkedIssues)
+	}
+	if len(issues.edits) != 3 {
+		t.Errorf("expected the pull request and both issues to be edited, got %v", issues.edits)
+	}
+}
+
+func TestLinkLabelFallback(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.3.0", "open")
+	issues.addMilestone("owner", "repo", 3, "v1.2.x", "open")
+	label := []github.Label{{Name: github.String("backport/1.2")}}
+	issues.addIssue(testPR, "closed", "Backports the fix").Labels = label
+	labelled := issues.addIssue(GitHubIssue{"owner", "repo", 20}, "open", "")
+	labelled.Labels = label
+	issues.byRepo["owner/repo"] = []*github.Issue{labelled}
+
+	opts := Options{LabelFallback: true, Milestone: MilestoneOptions{LabelMilestones: map[string]string{"backport/1.2": "v1.2.x"}}}
+	result, err := newTestLinker(t, issues, mergedPR, nil, opts).Link(context.Background(), testPR)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if expected := []GitHubIssue{{"owner", "repo", 20}}; !reflect.DeepEqual(result.ReferencedIssues, expected) {
+		t.Errorf("expected the labelled issue %v to be linked, got %v", expected, result.ReferencedIssues)
+	}
+	expected := []fakeEdit{{testPR, 3}, {GitHubIssue{"owner", "repo", 20}, 3}}
+	if !reflect.DeepEqual(issues.edits, expected) {
+		t.Errorf("expected edits %v, got %v", expected, issues.edits)
+	}
+}
+
+func TestLinkSkipPR(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "Fixes #12")
+	issues.addIssue(GitHubIssue{"owner", "repo", 12}, "closed", "")
+
+	result, err := newTestLinker(t, issues, mergedPR, nil, Options{SkipPR: true}).Link(context.Background(), testPR)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := []fakeEdit{{GitHubIssue{"owner", "repo", 12}, 2}}
+	if !reflect.DeepEqual(issues.edits, expected) {
+		t.Errorf("expected only the issue to be edited, got %v", issues.edits)
+	}
+	if len(result.Changes) != 1 || result.Changes[0].Issue == testPR {
+		t.Errorf("expected only the issue to change, got %+v", result.Changes)
+	}
+}
+
+func TestLinkRequireLabel(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels string
+		linked bool
+	}{
+		{"missing the label", `[{"name": "bug"}]`, false},
+		{"labelled", `[{"name": "bug"}, {"name": "Release-Note"}]`, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+			issues.addIssue(testPR, "closed", "")
+
+			pr := fmt.Sprintf(`{"number": 1, "merged": true, "state": "closed", "labels": %s, "base": {"ref": "main"}}`, tc.labels)
+			result, err := newTestLinker(t, issues, pr, nil, Options{RequireLabel: "release-note"}).Link(context.Background(), testPR)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if linked := result != nil && len(issues.edits) == 1; linked != tc.linked {
+				t.Errorf("expected linked to be %t, got %+v and edits %v", tc.linked, result, issues.edits)
+			}
+		})
+	}
+}
+
+func TestLinkBackportMilestone(t *testing.T) {
+	cases := []struct {
+		name     string
+		labels   string
+		expected int
+	}{
+		{"labelled backport", `[{"name": "backport"}]`, 3},
+		{"other pull request", `[]`, 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			issues.addMilestone("owner", "repo", 2, "v1.3.0", "open")
+			issues.addMilestone("owner", "repo", 3, "v1.2.x", "open")
+			issues.addMilestone("owner", "repo", 4, "v1.1.x", "open")
+			issues.addIssue(testPR, "closed", "")
+
+			backports, err := NewPatternScheme(`^v(?P<version>\d+\.\d+)\.x$`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pr := fmt.Sprintf(`{"number": 1, "title": "Fix a crash", "merged": true, "state": "closed", "labels": %s, "base": {"ref": "main"}}`, tc.labels)
+			opts := Options{Milestone: MilestoneOptions{Selection: SelectionHighest, BackportScheme: backports, BackportLabel: "backport"}}
+			if _, err := newTestLinker(t, issues, pr, nil, opts).Link(context.Background(), testPR); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			expected := []fakeEdit{{testPR, tc.expected}}
+			if !reflect.DeepEqual(issues.edits, expected) {
+				t.Errorf("expected edits %v, got %v", expected, issues.edits)
+			}
+		})
+	}
+}
+
+func TestLinkTwice(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "Fixes #12")
+	issues.addIssue(GitHubIssue{"owner", "repo", 12}, "closed", "")
+
+	l := newTestLinker(t, issues, mergedPR, nil, Options{})
+	if _, err := l.Link(context.Background(), testPR); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	edits := issues.calls["Edit"]
+
+	result, err := l.Link(context.Background(), testPR)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if issues.calls["Edit"] != edits || len(result.Changes) > 0 {
+		t.Errorf("expected nothing to be edited again, got %d edits and changes %+v", issues.calls["Edit"]-edits, result.Changes)
+	}
+}
+
+func TestLinkRepos(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addMilestone("owner", "api", 5, "v1.0.0", "open")
+	issues.addMilestone("owner", "web", 7, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "Fixes #12, #13 and #14")
+	// #12 exists in both repositories, #13 only in the second and #14 in neither
+	issues.addIssue(GitHubIssue{"owner", "api", 12}, "closed", "")
+	issues.addIssue(GitHubIssue{"owner", "web", 12}, "closed", "")
+	issues.addIssue(GitHubIssue{"owner", "web", 13}, "closed", "")
+	issues.addIssue(GitHubIssue{"owner", "repo", 14}, "closed", "")
+	logged := captureLog(t)
+
+	opts := Options{LinkRepos: []string{"owner/api", "owner/web"}}
+	result, err := newTestLinker(t, issues, mergedPR, nil, opts).Link(context.Background(), testPR)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := []GitHubIssue{{"owner", "api", 12}, {"owner", "web", 13}, {"owner", "repo", 14}}
+	if !reflect.DeepEqual(result.LinkedIssues, expected) {
+		t.Errorf("expected linked issues %v, got %v", expected, result.LinkedIssues)
+	}
+	edited := make(map[GitHubIssue]int)
+	for _, e := range issues.edits {
+		edited[e.Issue] = e.Milestone
+	}
+	expectedEdits := map[GitHubIssue]int{testPR: 2, {"owner", "api", 12}: 5, {"owner", "web", 13}: 7, {"owner", "repo", 14}: 2}
+	if !reflect.DeepEqual(edited, expectedEdits) {
+		t.Errorf("expected edits %v, got %v", expectedEdits, edited)
+	}
+	if !strings.Contains(logged.String(), "issue #12 resolved to owner/api#12") {
+		t.Errorf("expected the repository #12 resolved to to be logged, got %q", logged.String())
+	}
+}
+
+func TestLinkVerify(t *testing.T) {
+	for _, dropped := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dropped edits %t", dropped), func(t *testing.T) {
+			issues := newFakeIssues()
+			issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+			issues.addIssue(testPR, "closed", "Fixes #12")
+			issues.addIssue(GitHubIssue{"owner", "repo", 12}, "closed", "")
+			// the edits are answered without an issue, so only fetching the issue again shows whether they stuck
+			issues.emptyEdits = true
+			issues.dropEdits = dropped
+
+			opts := Options{Update: UpdateOptions{Verify: true}}
+			_, err := newTestLinker(t, issues, mergedPR, nil, opts).Link(context.Background(), testPR)
+			if dropped && (err == nil || !strings.Contains(err.Error(), "verifying milestone")) {
+				t.Errorf("expected the milestone not sticking to be reported, got %+v", err)
+			}
+			if !dropped && err != nil {
+				t.Errorf("unexpected error: %+v", err)
+			}
+		})
+	}
+}
+
+func TestLinkForeignMilestone(t *testing.T) {
+	other := GitHubIssue{"other", "project", 34}
+	cases := []struct {
+		name       string
+		milestones [][2]string
+		expected   int
+	}{
+		{"same title", [][2]string{{"v0.1.0", "open"}, {"v1.0.0", "open"}}, 2},
+		{"own version milestone", [][2]string{{"v0.1.0", "closed"}, {"v0.2.0", "open"}, {"v0.3.0", "open"}}, 2},
+		{"no version milestone", [][2]string{{"Backlog", "open"}}, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues := newFakeIssues()
+			issues.addMilestone("owner", "repo", 5, "v1.0.0", "open")
+			for i, m := range tc.milestones {
+				issues.addMilestone(other.Owner, other.Repo, i+1, m[0], m[1])
+			}
+			issues.addIssue(testPR, "closed", "Fixes other/project#34")
+			issues.addIssue(other, "closed", "")
+
+			_, err := newTestLinker(t, issues, mergedPR, nil, Options{}).Link(context.Background(), testPR)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			// milestone 5 is the pull request's repository's, which the other repository's issue must not be given
+			expected := []fakeEdit{{testPR, 5}}
+			if tc.expected != 0 {
+				expected = append(expected, fakeEdit{other, tc.expected})
+			}
+			if !reflect.DeepEqual(issues.edits, expected) {
+				t.Errorf("expected edits %v, got %v", expected, issues.edits)
+			}
+		})
+	}
+}
+
+func TestLinkTitleVersion(t *testing.T) {
+	cases := []struct {
+		title    string
+		expected int
+	}{
+		{"Release v1.5.0", 3},
+		{"Release 1.5.0", 3},
+		{"Release v1.9.0", 2},
+		{"Fix a bug", 2},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			issues := newFakeIssues()
+			issues.addMilestone("owner", "repo", 2, "v1.4.0", "open")
+			issues.addMilestone("owner", "repo", 3, "v1.5.0", "open")
+			issues.addIssue(testPR, "closed", "")
+
+			pr := fmt.Sprintf(`{"number": 1, "merged": true, "state": "closed", "title": %q, "base": {"ref": "main"}}`, tc.title)
+			opts := Options{Milestone: MilestoneOptions{
+				Selection:           SelectionLowest,
+				Scheme:              SemverScheme{},
+				TitleVersionPattern: regexp.MustCompile(`^Release (\S+)$`),
+			}}
+			result, err := newTestLinker(t, issues, pr, nil, opts).Link(context.Background(), testPR)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if result.Milestone.GetNumber() != tc.expected {
+				t.Errorf("expected milestone %d, got %q (%d)", tc.expected, result.Milestone.GetTitle(), result.Milestone.GetNumber())
+			}
+		})
+	}
+}
+
+func TestLinkGraphQLMode(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "")
+	issues.addIssue(GitHubIssue{"owner", "repo", 12}, "closed", "")
+	routes := map[string]string{
+		"POST /graphql": `{"data": {"repository": {"pullRequest": {"closingIssuesReferences": {"nodes": [{"number": 12, "repository": {"name": "repo", "owner": {"login": "owner"}}}]}}}}}`,
+	}
+
+	result, err := newTestLinker(t, issues, mergedPR, routes, Options{LinkMode: LinkModeGraphQL}).Link(context.Background(), testPR)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	// the issue is linked from the sidebar, not the empty description
+	expected := []GitHubIssue{{"owner", "repo", 12}}
+	if !reflect.DeepEqual(result.LinkedIssues, expected) {
+		t.Errorf("expected linked issues %v, got %v", expected, result.LinkedIssues)
+	}
+}
+
+func TestLinkGetsIssuesOnce(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addMilestone("owner", "api", 5, "v1.0.0", "open")
+	issues.addMilestone("other", "project", 7, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "Fixes #12 and other/project#34")
+	issues.addIssue(GitHubIssue{"owner", "api", 12}, "closed", "")
+	issues.addIssue(GitHubIssue{"other", "project", 34}, "closed", "")
+
+	// the issues looked up in LINK_REPOS and checked for access in another repository aren't fetched again to be linked
+	client := newTestClient(t, map[string]string{"GET /repos/owner/repo/pulls/1": mergedPR})
+	opts := Options{LinkRepos: []string{"owner/api"}}
+	if _, err := newLinker(client, newIssueCache(issues), opts).Link(context.Background(), testPR); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(issues.edits) != 3 {
+		t.Errorf("expected the pull request and both issues to be linked, got %v", issues.edits)
+	}
+	for _, issue := range []string{"owner/api#12", "other/project#34"} {
+		if n := issues.calls["Get "+issue]; n != 1 {
+			t.Errorf("expected %s to be fetched once, got %d fetches", issue, n)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package linker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+const (
+	LogLevelError = "error"
+	LogLevelWarn  = "warn"
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+// logLevels orders the levels from the least to the most verbose.
+var logLevels = map[string]int{
+	LogLevelError: 0,
+	LogLevelWarn:  1,
+	LogLevelInfo:  2,
+	LogLevelDebug: 3,
+}
+
+// ValidLogLevel reports whether level is one of the log levels.
+func ValidLogLevel(level string) bool {
+	_, ok := logLevels[level]
+	return ok
+}
+
+var jsonLogger = log.New(os.Stderr, "", 0)
+
+// LogFields are the structured fields attached to a log line. Empty fields are left out.
+type LogFields struct {
+	// PR is the pull request being linked when the line was logged, as owner/repo#number. It is filled in from the
+	// context the line is logged with, so callers leave it empty.
+	PR string `json:"pr,omitempty"`
+	// Issue is the issue or pull request the line is about, as owner/repo#number.
+	Issue string `json:"issue,omitempty"`
+	// Milestone is the title of the milestone the line is about.
+	Milestone string `json:"milestone,omitempty"`
+}
+
+// Logger writes log lines. Its zero value writes text lines at LogLevelInfo.
+type Logger struct {
+	// Format is how lines are written, either LogFormatText or LogFormatJSON. Empty is LogFormatText.
+	Format string
+	// Level is the most verbose level that is written, lines at more verbose levels are dropped. Empty is
+	// LogLevelInfo.
+	Level string
+
+	// pr is the pull request the lines are about, if any.
+	pr string
+}
+
+// loggerKey is the context key of the Logger that lines logged with the context are written by.
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx that WithRetry and LogRate log with.
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// withLogScope returns a copy of ctx whose logger attaches pr to every line, so lines can be told apart in logs
+// aggregated across repositories, also when pull requests are linked concurrently.
+func withLogScope(ctx context.Context, pr GitHubIssue) context.Context {
+	l := loggerFrom(ctx)
+	l.pr = pr.String()
+	return WithLogger(ctx, l)
+}
+
+// loggerFrom returns the logger set on ctx by WithLogger, scoped to a pull request by withLogScope, or the zero
+// Logger when there is none.
+func loggerFrom(ctx context.Context) Logger {
+	l, _ := ctx.Value(loggerKey{}).(Logger)
+	return l
+}
+
+type jsonLogLine struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+	LogFields
+}
+
+// logf writes a log line at level. Text lines are prefixed with the level and pull request, e.g.
+// "[DEBUG] owner/repo#12: ...", while JSON lines carry the level, message and fields as separate keys.
+func (l Logger) logf(level string, fields LogFields, format string, args ...interface{}) {
+	max, ok := logLevels[l.Level]
+	if !ok {
+		max = logLevels[LogLevelInfo]
+	}
+	if logLevels[level] > max {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if fields.PR == "" {
+		fields.PR = l.pr
+	}
+
+	if l.Format == LogFormatJSON {
+		b, err := json.Marshal(jsonLogLine{
+			Time:      time.Now().UTC().Format(time.RFC3339),
+			Level:     level,
+			Msg:       msg,
+			LogFields: fields,
+		})
+		if err == nil {
+			jsonLogger.Println(string(b))
+			return
+		}
+	}
+
+	if fields.PR != "" {
+		msg = fields.PR + ": " + msg
+	}
+	log.Printf("[%s] %s", strings.ToUpper(level), msg)
+}
+
+func (l Logger) Debugf(fields LogFields, format string, args ...interface{}) {
+	l.logf(LogLevelDebug, fields, format, args...)
+}
+
+func (l Logger) Infof(fields LogFields, format string, args ...interface{}) {
+	l.logf(LogLevelInfo, fields, format, args...)
+}
+
+func (l Logger) Warnf(fields LogFields, format string, args ...interface{}) {
+	l.logf(LogLevelWarn, fields, format, args...)
+}
+
+func (l Logger) Errorf(fields LogFields, format string, args ...interface{}) {
+	l.logf(LogLevelError, fields, format, args...)
+}
@@ -0,0 +1,86 @@
+package linker
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLogLevel(t *testing.T) {
+	logged := captureLog(t)
+
+	l := Logger{Level: LogLevelInfo}
+	l.Debugf(LogFields{}, "listing milestones")
+	l.Infof(LogFields{}, "set milestone v1.0.0")
+	l.Warnf(LogFields{}, "milestone v1.0.0 was closed")
+
+	if strings.Contains(logged.String(), "listing milestones") {
+		t.Errorf("expected debug lines to be left out at info level, got %q", logged.String())
+	}
+	if !strings.Contains(logged.String(), "[INFO] set milestone v1.0.0") || !strings.Contains(logged.String(), "[WARN] milestone v1.0.0 was closed") {
+		t.Errorf("expected info and warning lines to be written, got %q", logged.String())
+	}
+}
+
+func TestLinkLogsPullRequest(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "Fixes #12")
+	issues.addIssue(GitHubIssue{"owner", "repo", 12}, "closed", "")
+	logged := captureLog(t)
+
+	if _, err := newTestLinker(t, issues, mergedPR, nil, Options{}).Link(context.Background(), testPR); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	Logger{}.Infof(LogFields{}, "linked every pull request")
+
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected lines to be logged, got %q", logged.String())
+	}
+	for _, line := range lines[:len(lines)-1] {
+		if !strings.Contains(line, "] owner/repo#1: ") {
+			t.Errorf("expected the line to name the pull request, got %q", line)
+		}
+	}
+	if last := lines[len(lines)-1]; strings.Contains(last, "owner/repo#1") {
+		t.Errorf("expected lines logged after linking not to name the pull request, got %q", last)
+	}
+}
+
+func TestLogScopeIsPerContext(t *testing.T) {
+	logged := captureLog(t)
+	ctx := context.Background()
+
+	loggerFrom(withLogScope(ctx, GitHubIssue{"owner", "repo", 1})).Infof(LogFields{}, "first")
+	loggerFrom(withLogScope(ctx, GitHubIssue{"owner", "repo", 2})).Infof(LogFields{}, "second")
+	loggerFrom(ctx).Infof(LogFields{}, "third")
+
+	for _, expected := range []string{"[INFO] owner/repo#1: first\n", "[INFO] owner/repo#2: second\n", "[INFO] third\n"} {
+		if !strings.Contains(logged.String(), expected) {
+			t.Errorf("expected %q to be logged, got %q", expected, logged.String())
+		}
+	}
+}
+
+func TestLinkersLogWithTheirOwnSettings(t *testing.T) {
+	issues := newFakeIssues()
+	issues.addMilestone("owner", "repo", 2, "v1.0.0", "open")
+	issues.addIssue(testPR, "closed", "")
+	logged := captureLog(t)
+
+	quiet := newTestLinker(t, issues, mergedPR, nil, Options{Logger: Logger{Level: LogLevelWarn}})
+	verbose := newTestLinker(t, issues, mergedPR, nil, Options{Logger: Logger{Level: LogLevelDebug}})
+	if _, err := quiet.Link(context.Background(), testPR); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if logged.Len() != 0 {
+		t.Errorf("expected nothing to be logged at warn level, got %q", logged.String())
+	}
+	if _, err := verbose.Link(context.Background(), testPR); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if !strings.Contains(logged.String(), "[DEBUG] ") {
+		t.Errorf("expected debug lines to be logged at debug level, got %q", logged.String())
+	}
+}
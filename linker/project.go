@@ -0,0 +1,351 @@
+package linker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// ProjectColumn names a column of a project of the repository or its owner, which is the value of the project's Status
+// field, or on GitHub Enterprise Server a column of a classic project board.
+type ProjectColumn struct {
+	Project string
+	Column  string
+}
+
+func (c ProjectColumn) String() string {
+	return c.Project + "/" + c.Column
+}
+
+// projectCardURL matches the API URL of the issue a project card is for.
+var projectCardURL = regexp.MustCompile(`/repos/([^/]+)/([^/]+)/issues/([0-9]+)$`)
+
+// projectStatusField is the field of a project whose values are the columns of its board.
+const projectStatusField = "Status"
+
+// getProjectColumnIssues returns the issues, and pull requests, in the project column. The project is looked up among
+// the repository's projects first and then among its owner's. GitHub Enterprise Server may predate projects, or only
+// have classic project boards, which are used when no such project is found there.
+func (g GitHubIssue) getProjectColumnIssues(ctx context.Context, client *github.Client, column ProjectColumn) (map[GitHubIssue]bool, error) {
+	inColumn, err := g.getProjectStatusIssues(ctx, client, column)
+	if graphqlURL(client) == "graphql" {
+		if err == nil && inColumn == nil {
+			return nil, fmt.Errorf("project %s doesn't exist in %s/%s or its owner", column.Project, g.Owner, g.Repo)
+		}
+		return inColumn, err
+	}
+	if err == nil && inColumn != nil {
+		return inColumn, nil
+	}
+
+	if err != nil {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "looking up project %s failed, trying classic project boards: %+v", column.Project, err)
+	}
+	return g.getClassicProjectColumnIssues(ctx, client, column)
+}
+
+const projectsQuery = `query($owner: String!, $repo: String!, $name: String!) {
+  repository(owner: $owner, name: $repo) {
+    projectsV2(first: 100, query: $name) {
+      nodes {
+        id
+        title
+      }
+    }
+  }
+  repositoryOwner(login: $owner) {
+    ... on Organization {
+      projectsV2(first: 100, query: $name) {
+        nodes {
+          id
+          title
+        }
+      }
+    }
+    ... on User {
+      projectsV2(first: 100, query: $name) {
+        nodes {
+          id
+          title
+        }
+      }
+    }
+  }
+}`
+
+type projectNodes struct {
+	ProjectsV2 struct {
+		Nodes []struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"nodes"`
+	} `json:"projectsV2"`
+}
+
+type projectsResponse struct {
+	Data struct {
+		Repository      *projectNodes `json:"repository"`
+		RepositoryOwner *projectNodes `json:"repositoryOwner"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+const projectItemsQuery = `query($id: ID!, $after: String) {
+  node(id: $id) {
+    ... on ProjectV2 {
+      items(first: 100, after: $after) {
+        pageInfo {
+          hasNextPage
+          endCursor
+        }
+        nodes {
+          fieldValueByName(name: "` + projectStatusField + `") {
+            ... on ProjectV2ItemFieldSingleSelectValue {
+              name
+            }
+          }
+          content {
+            ... on Issue {
+              number
+              repository {
+                name
+                owner {
+                  login
+                }
+              }
+            }
+            ... on PullRequest {
+              number
+              repository {
+                name
+                owner {
+                  login
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+type projectItemsResponse struct {
+	Data struct {
+		Node struct {
+			Items struct {
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
+				Nodes []struct {
+					FieldValueByName *struct {
+						Name string `json:"name"`
+					} `json:"fieldValueByName"`
+					// Content is empty for draft issues
+					Content struct {
+						Number     int `json:"number"`
+						Repository struct {
+							Name  string `json:"name"`
+							Owner struct {
+								Login string `json:"login"`
+							} `json:"owner"`
+						} `json:"repository"`
+					} `json:"content"`
+				} `json:"nodes"`
+			} `json:"items"`
+		} `json:"node"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// getProjectStatusIssues returns the issues and pull requests of the project named column.Project whose Status is
+// column.Column, or nil when neither the repository nor its owner has such a project.
+func (g GitHubIssue) getProjectStatusIssues(ctx context.Context, client *github.Client, column ProjectColumn) (map[GitHubIssue]bool, error) {
+	query := graphqlRequest{
+		Query:     projectsQuery,
+		Variables: map[string]interface{}{"owner": g.Owner, "repo": g.Repo, "name": column.Project},
+	}
+	var projects projectsResponse
+	if err := queryGraphQL(ctx, client, query, &projects); err != nil {
+		return nil, fmt.Errorf("querying projects of %s/%s: %+v", g.Owner, g.Repo, err)
+	}
+	if len(projects.Errors) > 0 {
+		return nil, fmt.Errorf("querying projects of %s/%s: %s", g.Owner, g.Repo, projects.Errors[0].Message)
+	}
+
+	// the query matches projects by words in their title, so the title is compared as for classic projects
+	projectId := ""
+	for _, nodes := range []*projectNodes{projects.Data.Repository, projects.Data.RepositoryOwner} {
+		if nodes == nil || projectId != "" {
+			continue
+		}
+		for _, p := range nodes.ProjectsV2.Nodes {
+			if strings.EqualFold(strings.TrimSpace(p.Title), column.Project) {
+				projectId = p.ID
+				break
+			}
+		}
+	}
+	if projectId == "" {
+		return nil, nil
+	}
+
+	inColumn := make(map[GitHubIssue]bool)
+	variables := map[string]interface{}{"id": projectId, "after": nil}
+	for {
+		var items projectItemsResponse
+		if err := queryGraphQL(ctx, client, graphqlRequest{Query: projectItemsQuery, Variables: variables}, &items); err != nil {
+			return nil, fmt.Errorf("querying items of project %s: %+v", column.Project, err)
+		}
+		if len(items.Errors) > 0 {
+			return nil, fmt.Errorf("querying items of project %s: %s", column.Project, items.Errors[0].Message)
+		}
+
+		page := items.Data.Node.Items
+		for _, item := range page.Nodes {
+			if item.FieldValueByName == nil || item.Content.Number == 0 {
+				continue
+			}
+			if strings.EqualFold(strings.TrimSpace(item.FieldValueByName.Name), column.Column) {
+				c := item.Content
+				inColumn[GitHubIssue{c.Repository.Owner.Login, c.Repository.Name, c.Number}] = true
+			}
+		}
+
+		if !page.PageInfo.HasNextPage {
+			break
+		}
+		variables["after"] = page.PageInfo.EndCursor
+	}
+
+	loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "found %d issues with %s %s in project %s", len(inColumn), projectStatusField, column.Column, column.Project)
+	return inColumn, nil
+}
+
+// getClassicProjectColumnIssues returns the issues with a card in the column of a classic project board. The project
+// is looked up among the repository's projects first and then among its organisation's.
+func (g GitHubIssue) getClassicProjectColumnIssues(ctx context.Context, client *github.Client, column ProjectColumn) (map[GitHubIssue]bool, error) {
+	project, err := g.findProject(ctx, client, column.Project)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, fmt.Errorf("project %s doesn't exist in %s/%s or its organisation", column.Project, g.Owner, g.Repo)
+	}
+
+	var columnId int64
+	listOpts := &github.ListOptions{PerPage: 100}
+	for columnId == 0 {
+		var columns []*github.ProjectColumn
+		var resp *github.Response
+		err := WithRetry(ctx, func() (err error) {
+			columns, resp, err = client.Projects.ListProjectColumns(ctx, project.GetID(), listOpts)
+			LogRate(ctx, resp)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing columns of project %s: %+v", column.Project, err)
+		}
+
+		for _, c := range columns {
+			if strings.EqualFold(strings.TrimSpace(c.GetName()), column.Column) {
+				columnId = c.GetID()
+				break
+			}
+		}
+
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		listOpts.Page = resp.NextPage
+	}
+	if columnId == 0 {
+		return nil, fmt.Errorf("project %s has no column %s", column.Project, column.Column)
+	}
+
+	inColumn := make(map[GitHubIssue]bool)
+	cardOpts := &github.ProjectCardListOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		var cards []*github.ProjectCard
+		var resp *github.Response
+		err := WithRetry(ctx, func() (err error) {
+			cards, resp, err = client.Projects.ListProjectCards(ctx, columnId, cardOpts)
+			LogRate(ctx, resp)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing cards of project column %s: %+v", column, err)
+		}
+
+		// notes have no content URL and pull requests are cards for their issue URL too
+		for _, c := range cards {
+			if match := projectCardURL.FindStringSubmatch(c.GetContentURL()); match != nil {
+				id, _ := strconv.Atoi(match[3])
+				inColumn[GitHubIssue{match[1], match[2], id}] = true
+			}
+		}
+
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		cardOpts.Page = resp.NextPage
+	}
+
+	loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "found %d issues in project column %s", len(inColumn), column)
+	return inColumn, nil
+}
+
+// findProject returns the open project named name of the repository or, failing that, of its organisation, or nil
+// when neither has one.
+func (g GitHubIssue) findProject(ctx context.Context, client *github.Client, name string) (*github.Project, error) {
+	list := []func(*github.ProjectListOptions) ([]*github.Project, *github.Response, error){
+		func(opts *github.ProjectListOptions) ([]*github.Project, *github.Response, error) {
+			return client.Repositories.ListProjects(ctx, g.Owner, g.Repo, opts)
+		},
+		func(opts *github.ProjectListOptions) ([]*github.Project, *github.Response, error) {
+			return client.Organizations.ListProjects(ctx, g.Owner, opts)
+		},
+	}
+
+	for _, listProjects := range list {
+		opts := &github.ProjectListOptions{State: "open", ListOptions: github.ListOptions{PerPage: 100}}
+		for {
+			var projects []*github.Project
+			var resp *github.Response
+			err := WithRetry(ctx, func() (err error) {
+				projects, resp, err = listProjects(opts)
+				LogRate(ctx, resp)
+				return err
+			})
+			// users have no organisation projects
+			if resp != nil && resp.Response != nil && resp.StatusCode == http.StatusNotFound {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("listing projects of %s/%s: %+v", g.Owner, g.Repo, err)
+			}
+
+			for _, p := range projects {
+				if strings.EqualFold(strings.TrimSpace(p.GetName()), name) {
+					return p, nil
+				}
+			}
+
+			if resp == nil || resp.NextPage == 0 {
+				break
+			}
+			opts.Page = resp.NextPage
+		}
+	}
+	return nil, nil
+}
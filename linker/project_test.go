@@ -0,0 +1,137 @@
+package linker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// projectItems are the items of the Roadmap project served by newProjectClient, in two pages.
+var projectItems = [][]string{
+	{
+		`{"fieldValueByName": {"name": "Done"}, "content": {"number": 12, "repository": {"name": "repo", "owner": {"login": "owner"}}}}`,
+		`{"fieldValueByName": {"name": "In progress"}, "content": {"number": 13, "repository": {"name": "repo", "owner": {"login": "owner"}}}}`,
+		`{"fieldValueByName": {"name": "Done"}, "content": {}}`,
+	},
+	{
+		`{"fieldValueByName": null, "content": {"number": 14, "repository": {"name": "repo", "owner": {"login": "owner"}}}}`,
+		`{"fieldValueByName": {"name": "done"}, "content": {"number": 34, "repository": {"name": "project", "owner": {"login": "other"}}}}`,
+	},
+}
+
+// newProjectClient returns a client for a test server with the Roadmap project, owned by the repository's owner, when
+// projects is set, and the classic Roadmap project board of the repository. With enterprise set the server is at
+// /api/v3/ as GitHub Enterprise Server is, and the GraphQL API fails unless projects is set.
+func newProjectClient(t *testing.T, projects bool, enterprise bool) *github.Client {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		var query graphqlRequest
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case !projects && enterprise:
+			fmt.Fprint(w, `{"errors": [{"message": "Field 'projectsV2' doesn't exist on type 'Repository'"}]}`)
+		case !projects:
+			fmt.Fprint(w, `{"data": {"repository": {"projectsV2": {"nodes": []}}, "repositoryOwner": {"projectsV2": {"nodes": []}}}}`)
+		case strings.Contains(query.Query, "projectsV2"):
+			fmt.Fprint(w, `{"data": {"repository": {"projectsV2": {"nodes": [{"id": "P_2", "title": "Roadmap 2"}]}}, "repositoryOwner": {"projectsV2": {"nodes": [{"id": "P_1", "title": "roadmap"}]}}}}`)
+		case query.Variables["id"] != "P_1":
+			fmt.Fprint(w, `{"errors": [{"message": "Could not resolve to a node"}]}`)
+		case query.Variables["after"] == nil:
+			fmt.Fprintf(w, `{"data": {"node": {"items": {"pageInfo": {"hasNextPage": true, "endCursor": "c1"}, "nodes": [%s]}}}}`, strings.Join(projectItems[0], ","))
+		default:
+			fmt.Fprintf(w, `{"data": {"node": {"items": {"pageInfo": {"hasNextPage": false, "endCursor": "c2"}, "nodes": [%s]}}}}`, strings.Join(projectItems[1], ","))
+		}
+	})
+	mux.HandleFunc("/repos/owner/repo/projects", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 7, "name": "Roadmap"}]`)
+	})
+	mux.HandleFunc("/projects/7/columns", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 70, "name": "To do"}, {"id": 71, "name": "Done"}]`)
+	})
+	mux.HandleFunc("/projects/columns/71/cards", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"content_url": "https://github.example.com/api/v3/repos/owner/repo/issues/15"}, {"note": "Release"}]`)
+	})
+
+	prefix := ""
+	if enterprise {
+		prefix = "/api/v3"
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if enterprise && r.URL.Path == "/api/graphql" {
+			r.URL.Path = "/graphql"
+		} else if !strings.HasPrefix(r.URL.Path, prefix+"/") || (enterprise && strings.HasPrefix(r.URL.Path, "/graphql")) {
+			http.NotFound(w, r)
+			return
+		} else {
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + prefix + "/")
+	return client
+}
+
+func TestGetProjectColumnIssues(t *testing.T) {
+	cases := []struct {
+		name       string
+		projects   bool
+		enterprise bool
+		expected   map[GitHubIssue]bool
+		err        bool
+	}{
+		{
+			name:     "project status",
+			projects: true,
+			expected: map[GitHubIssue]bool{{"owner", "repo", 12}: true, {"other", "project", 34}: true},
+		},
+		{
+			name: "no project",
+			err:  true,
+		},
+		{
+			name:       "project status on github enterprise server",
+			projects:   true,
+			enterprise: true,
+			expected:   map[GitHubIssue]bool{{"owner", "repo", 12}: true, {"other", "project", 34}: true},
+		},
+		{
+			name:       "classic project board on github enterprise server",
+			enterprise: true,
+			expected:   map[GitHubIssue]bool{{"owner", "repo", 15}: true},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newProjectClient(t, tc.projects, tc.enterprise)
+
+			inColumn, err := testPR.getProjectColumnIssues(context.Background(), client, ProjectColumn{"Roadmap", "Done"})
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", inColumn)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(inColumn, tc.expected) {
+				t.Errorf("expected issues %v, got %v", tc.expected, inColumn)
+			}
+		})
+	}
+}
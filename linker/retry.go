@@ -0,0 +1,102 @@
+package linker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// DefaultMaxRetries is the number of times a GitHub API call is retried after hitting a rate limit, unless set
+// otherwise with WithMaxRetries.
+const DefaultMaxRetries = 3
+
+// maxRetriesKey is the context key of the number of times WithRetry retries a call.
+type maxRetriesKey struct{}
+
+// WithMaxRetries returns a copy of ctx with which WithRetry retries a call up to n times.
+func WithMaxRetries(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, maxRetriesKey{}, n)
+}
+
+// maxRetriesFrom returns the number of retries set on ctx by WithMaxRetries, or DefaultMaxRetries.
+func maxRetriesFrom(ctx context.Context) int {
+	if n, ok := ctx.Value(maxRetriesKey{}).(int); ok {
+		return n
+	}
+	return DefaultMaxRetries
+}
+
+// retryBaseDelay is the delay before the first retry when GitHub doesn't say how long to wait, doubled on every
+// subsequent retry.
+var retryBaseDelay = time.Second
+
+// WithRetry calls fn, retrying with exponential backoff for as long as it fails on a primary or secondary rate limit
+// and the retries set on ctx by WithMaxRetries aren't exhausted. Any other error is returned immediately.
+func WithRetry(ctx context.Context, fn func() error) error {
+	maxRetries := maxRetriesFrom(ctx)
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= maxRetries {
+			return err
+		}
+
+		wait := delay
+		switch e := err.(type) {
+		case *github.RateLimitError:
+			if reset := time.Until(e.Rate.Reset.Time); reset > wait {
+				wait = reset
+			}
+		case *github.AbuseRateLimitError:
+			if e.RetryAfter != nil && *e.RetryAfter > wait {
+				wait = *e.RetryAfter
+			}
+		default:
+			return err
+		}
+
+		loggerFrom(ctx).Debugf(LogFields{}, "rate limited by github, retrying in %s (attempt %d of %d)", wait, attempt+1, maxRetries)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// LogRate logs how much of the rate limit is left after a GitHub API response, to help diagnose throttling.
+func LogRate(ctx context.Context, resp *github.Response) {
+	if resp == nil || resp.Rate.Limit == 0 {
+		return
+	}
+	loggerFrom(ctx).Debugf(LogFields{}, "github rate limit: %d of %d requests remaining, resets at %s", resp.Rate.Remaining, resp.Rate.Limit, resp.Rate.Reset.Format(time.RFC3339))
+}
+
+// forbidden explains a 403 from GitHub as the token lacking permission, which is how fine-grained tokens without
+// access to the endpoint are rejected. Rate limit errors, which are 403s too, are returned as is so they are retried.
+func forbidden(resp *github.Response, err error, permission string) error {
+	switch err.(type) {
+	case nil, *github.RateLimitError, *github.AbuseRateLimitError:
+		return err
+	}
+	if resp != nil && resp.Response != nil && resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("token lacks %s access, grant it to the token or the workflow's permissions: %+v", permission, err)
+	}
+	return err
+}
+
+// noAccess reports whether a GitHub API call failed with a 403 or 404, which is how GitHub answers for a repository
+// the token can't read. Rate limit errors, which are 403s too, don't count, even when wrapped.
+func noAccess(resp *github.Response, err error) bool {
+	var rateLimit *github.RateLimitError
+	var abuseRateLimit *github.AbuseRateLimitError
+	if err == nil || errors.As(err, &rateLimit) || errors.As(err, &abuseRateLimit) {
+		return false
+	}
+	return resp != nil && resp.Response != nil && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound)
+}
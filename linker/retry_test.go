@@ -0,0 +1,135 @@
+package linker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// fastRetries shortens the delay between retries for the rest of the test.
+func fastRetries(t *testing.T) {
+	delay := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = delay })
+}
+
+func TestWithRetryRecoversFromRateLimit(t *testing.T) {
+	fastRetries(t)
+
+	calls := 0
+	err := WithRetry(context.Background(), func() error {
+		calls++
+		if calls == 1 {
+			return &github.AbuseRateLimitError{Message: "secondary rate limit"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestWithRetryStopsAfterMaxRetries(t *testing.T) {
+	fastRetries(t)
+
+	for _, maxRetries := range []int{0, 2} {
+		t.Run(fmt.Sprintf("%d retries", maxRetries), func(t *testing.T) {
+			calls := 0
+			err := WithRetry(WithMaxRetries(context.Background(), maxRetries), func() error {
+				calls++
+				return &github.AbuseRateLimitError{Message: "secondary rate limit"}
+			})
+			if err == nil {
+				t.Fatal("expected the rate limit error to be returned")
+			}
+			if calls != maxRetries+1 {
+				t.Errorf("expected %d calls, got %d", maxRetries+1, calls)
+			}
+		})
+	}
+}
+
+func TestWithRetryStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	retryAfter := time.Hour
+	err := WithRetry(ctx, func() error {
+		calls++
+		return &github.AbuseRateLimitError{Message: "secondary rate limit", RetryAfter: &retryAfter}
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected the context's error, got %+v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestForbidden(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"forbidden", statusError(http.StatusForbidden), "token lacks Issues write access, grant it to the token or the workflow's permissions"},
+		{"not found", statusError(http.StatusNotFound), "Not Found"},
+		{"rate limited", &github.RateLimitError{Response: statusError(http.StatusForbidden).Response, Message: "API rate limit exceeded"}, "API rate limit exceeded"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &github.Response{}
+			switch e := tc.err.(type) {
+			case *github.ErrorResponse:
+				resp.Response = e.Response
+			case *github.RateLimitError:
+				resp.Response = e.Response
+			}
+			err := forbidden(resp, tc.err, "Issues write")
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("expected an error saying %q, got %+v", tc.expected, err)
+			}
+			if tc.name != "forbidden" && err != tc.err {
+				t.Errorf("expected the error to be returned as is, got %+v", err)
+			}
+		})
+	}
+}
+
+func TestNoAccess(t *testing.T) {
+	rateLimited := &github.RateLimitError{Response: statusError(http.StatusForbidden).Response, Message: "API rate limit exceeded"}
+	cases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"forbidden", statusError(http.StatusForbidden), true},
+		{"not found", statusError(http.StatusNotFound), true},
+		{"server error", statusError(http.StatusInternalServerError), false},
+		{"rate limited", rateLimited, false},
+		{"wrapped rate limit", fmt.Errorf("getting issue owner/repo#12: %w", rateLimited), false},
+		{"no error", nil, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &github.Response{Response: statusError(http.StatusForbidden).Response}
+			if e, ok := tc.err.(*github.ErrorResponse); ok {
+				resp.Response = e.Response
+			}
+			if got := noAccess(resp, tc.err); got != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package linker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// timelineEvent is an event of an issue's timeline. Unlike github.Timeline it decodes the issue a cross-referenced
+// event comes from.
+type timelineEvent struct {
+	Event  string `json:"event"`
+	Source *struct {
+		Issue *github.Issue `json:"issue"`
+	} `json:"source"`
+}
+
+// ListIssueTimeline lists a page of the events of an issue's timeline.
+func (c issuesClient) ListIssueTimeline(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*timelineEvent, *github.Response, error) {
+	u := fmt.Sprintf("repos/%v/%v/issues/%d/timeline?per_page=%d&page=%d", owner, repo, number, opt.PerPage, opt.Page)
+	req, err := c.client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the timeline was a preview on older GitHub Enterprise Server versions
+	req.Header.Set("Accept", "application/vnd.github.mockingbird-preview+json")
+
+	var events []*timelineEvent
+	resp, err := c.client.Do(ctx, req, &events)
+	if err != nil {
+		return nil, resp, err
+	}
+	return events, resp, nil
+}
+
+// getTimelineLinkedIssues returns the issues that cross-reference the pull request in its timeline and that the pull
+// request cross-references in turn, for when neither its description nor the GraphQL API name any. An issue's own
+// timeline has to show the cross-reference from the pull request, so issues that merely mention it aren't linked.
+// Connected events are ignored, as the REST timeline doesn't say which issue they connect.
+func (g GitHubIssue) getTimelineLinkedIssues(ctx context.Context, issues issuesService) ([]GitHubIssue, error) {
+	events, _, err := g.listTimeline(ctx, issues)
+	if err != nil {
+		return nil, err
+	}
+
+	var linked []GitHubIssue
+	seen := make(map[GitHubIssue]bool)
+	for _, e := range events {
+		if e.Event != "cross-referenced" || e.Source == nil || e.Source.Issue == nil {
+			continue
+		}
+		// pull requests referencing this one are not the issues it resolves
+		issue := e.Source.Issue
+		if issue.IsPullRequest() {
+			continue
+		}
+
+		li := GitHubIssue{g.Owner, g.Repo, issue.GetNumber()}
+		li.Owner, li.Repo = issueRepository(issue, g.Owner, g.Repo)
+		if seen[li] {
+			continue
+		}
+		seen[li] = true
+
+		referenced, err := li.crossReferencedBy(ctx, issues, g)
+		if err != nil {
+			return nil, err
+		}
+		if !referenced {
+			loggerFrom(ctx).Debugf(LogFields{Issue: li.String()}, "issue %s mentions the pull request but isn't referenced by it, skipping", li)
+			continue
+		}
+		linked = append(linked, li)
+	}
+
+	if len(linked) == 0 {
+		loggerFrom(ctx).Debugf(LogFields{Issue: g.String()}, "no issues reference the pull request in its timeline")
+	}
+	return linked, nil
+}
+
+// crossReferencedBy reports whether the timeline of the issue g has a cross-referenced event coming from the pull
+// request pr. An issue the token can't read is reported as not referenced.
+func (g GitHubIssue) crossReferencedBy(ctx context.Context, issues issuesService, pr GitHubIssue) (bool, error) {
+	events, resp, err := g.listTimeline(ctx, issues)
+	if noAccess(resp, err) {
+		loggerFrom(ctx).Warnf(LogFields{Issue: g.String()}, "can't read the timeline of issue %s, skipping", g)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range events {
+		if e.Event != "cross-referenced" || e.Source == nil || e.Source.Issue == nil {
+			continue
+		}
+		source := GitHubIssue{Id: e.Source.Issue.GetNumber()}
+		source.Owner, source.Repo = issueRepository(e.Source.Issue, g.Owner, g.Repo)
+		if strings.EqualFold(source.String(), pr.String()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// listTimeline lists every event of the timeline of the issue or pull request g, along with the last response.
+func (g GitHubIssue) listTimeline(ctx context.Context, issues issuesService) ([]*timelineEvent, *github.Response, error) {
+	var all []*timelineEvent
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		var events []*timelineEvent
+		var resp *github.Response
+		err := WithRetry(ctx, func() (err error) {
+			events, resp, err = issues.ListIssueTimeline(ctx, g.Owner, g.Repo, g.Id, opts)
+			LogRate(ctx, resp)
+			return err
+		})
+		if err != nil {
+			return nil, resp, fmt.Errorf("listing timeline of %s: %+v", g, err)
+		}
+		all = append(all, events...)
+
+		if resp == nil || resp.NextPage == 0 {
+			return all, resp, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// issueRepository returns the owner and name of the repository of issue, taken from its repository or its API URL,
+// e.g. https://api.github.com/repos/owner/repo, or owner and repo when it has neither.
+func issueRepository(issue *github.Issue, owner string, repo string) (string, string) {
+	fullName := ""
+	if issue.Repository != nil {
+		fullName = issue.Repository.GetFullName()
+	}
+	if fullName == "" {
+		if i := strings.Index(issue.GetRepositoryURL(), "/repos/"); i >= 0 {
+			fullName = issue.GetRepositoryURL()[i+len("/repos/"):]
+		}
+	}
+
+	if parts := strings.Split(fullName, "/"); len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+		return parts[0], parts[1]
+	}
+	return owner, repo
+}
@@ -0,0 +1,39 @@
+package linker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGetTimelineLinkedIssues(t *testing.T) {
+	client := newTestClient(t, map[string]string{
+		"GET /repos/owner/repo/issues/1/timeline": `[
+			{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 12, "repository_url": "https://api.github.com/repos/owner/repo"}}},
+			{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 34, "repository_url": "https://api.github.com/repos/other/project"}}},
+			{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 56, "repository_url": "https://api.github.com/repos/owner/repo"}}},
+			{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 5, "pull_request": {"url": "https://api.github.com/repos/owner/repo/pulls/5"}}}},
+			{"event": "labeled"}
+		]`,
+		"GET /repos/owner/repo/issues/12/timeline": `[
+			{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 1, "repository_url": "https://api.github.com/repos/owner/repo", "pull_request": {"url": "https://api.github.com/repos/owner/repo/pulls/1"}}}}
+		]`,
+		"GET /repos/other/project/issues/34/timeline": `[
+			{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 1, "repository_url": "https://api.github.com/repos/owner/repo", "pull_request": {"url": "https://api.github.com/repos/owner/repo/pulls/1"}}}}
+		]`,
+		// issue 56 mentions the pull request, but the pull request doesn't reference it
+		"GET /repos/owner/repo/issues/56/timeline": `[
+			{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 1, "repository_url": "https://api.github.com/repos/other/project"}}}
+		]`,
+	})
+
+	linked, err := testPR.getTimelineLinkedIssues(context.Background(), issuesClient{client.Issues, client})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := []GitHubIssue{{"owner", "repo", 12}, {"other", "project", 34}}
+	if !reflect.DeepEqual(linked, expected) {
+		t.Errorf("expected issues %v, got %v", expected, linked)
+	}
+}
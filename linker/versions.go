@@ -0,0 +1,243 @@
+package linker
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+const (
+	SchemeSemver = "semver"
+	SchemeCalver = "calver"
+)
+
+// VersionScheme recognises the milestone titles that are versions and orders them.
+type VersionScheme interface {
+	// Match reports whether title is a version in this scheme.
+	Match(title string) bool
+	// Compare returns -1, 0 or 1 when version a is lower than, equal to or higher than b.
+	Compare(a string, b string) int
+	// Next returns the version following latest, or the first version when latest is empty.
+	Next(latest string, bump string) (string, error)
+}
+
+// sortVersions sorts versions from lowest to highest.
+func sortVersions(scheme VersionScheme, versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return scheme.Compare(versions[i], versions[j]) < 0
+	})
+}
+
+// versionRegexp matches semver milestone titles, capturing the major, minor and patch numbers.
+var versionRegexp = regexp.MustCompile(`^v([0-9]+)\.([0-9]+)\.([0-9]+)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// SemverScheme versions milestones as vMAJOR.MINOR.PATCH with an optional prerelease and build, e.g. v1.2.0-rc1.
+type SemverScheme struct{}
+
+func (SemverScheme) Match(title string) bool {
+	return versionRegexp.MatchString(title)
+}
+
+// Compare uses semver precedence, so prereleases sort before their release, e.g. v1.2.0-rc1 < v1.2.0.
+func (SemverScheme) Compare(a string, b string) int {
+	return semver.Compare(a, b)
+}
+
+// Next increments the patch, minor or major part of latest, dropping any prerelease or build suffix. The first
+// version is v0.1.0.
+func (SemverScheme) Next(latest string, bump string) (string, error) {
+	if latest == "" {
+		return "v0.1.0", nil
+	}
+
+	parts := versionRegexp.FindStringSubmatch(latest)
+	if parts == nil {
+		return "", fmt.Errorf("%q is not a version", latest)
+	}
+
+	major, _ := strconv.Atoi(parts[1])
+	minor, _ := strconv.Atoi(parts[2])
+	patch, _ := strconv.Atoi(parts[3])
+
+	switch bump {
+	case BumpMajor:
+		major, minor, patch = major+1, 0, 0
+	case BumpMinor:
+		minor, patch = minor+1, 0
+	case BumpPatch:
+		patch++
+	default:
+		return "", fmt.Errorf("unknown version bump %q", bump)
+	}
+
+	return fmt.Sprintf("v%d.%d.%d", major, minor, patch), nil
+}
+
+// calverRegexp matches calendar versioned milestone titles, capturing the year, month and optional patch numbers.
+var calverRegexp = regexp.MustCompile(`^v([0-9]{4})\.(0?[1-9]|1[0-2])(?:\.([0-9]+))?$`)
+
+// CalverScheme versions milestones as vYYYY.MM with an optional patch, e.g. v2024.05 or v2024.05.1.
+type CalverScheme struct{}
+
+func (CalverScheme) Match(title string) bool {
+	return calverRegexp.MatchString(title)
+}
+
+// Compare orders versions chronologically, with patches after the month they belong to.
+func (CalverScheme) Compare(a string, b string) int {
+	pa, pb := calverParts(a), calverParts(b)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Next returns the current month, or the next patch of latest when it is already the current month or later. bump
+// isn't used as calendar versions advance with time.
+func (s CalverScheme) Next(latest string, _ string) (string, error) {
+	now := time.Now().UTC()
+	current := fmt.Sprintf("v%04d.%02d", now.Year(), now.Month())
+	if latest == "" || s.Compare(latest, current) < 0 {
+		return current, nil
+	}
+
+	parts := calverParts(latest)
+	return fmt.Sprintf("v%04d.%02d.%d", parts[0], parts[1], parts[2]+1), nil
+}
+
+// calverParts returns the year, month and patch of a calendar version, all zero when it isn't one.
+func calverParts(version string) [3]int {
+	var parts [3]int
+	m := calverRegexp.FindStringSubmatch(version)
+	if m == nil {
+		return parts
+	}
+	for i := range parts {
+		parts[i], _ = strconv.Atoi(m[i+1])
+	}
+	return parts
+}
+
+// patternScheme versions milestones whose title matches a custom pattern, ordered by the part captured by its
+// "version" group, e.g. `Release (?P<version>[0-9.]+)`.
+type patternScheme struct {
+	pattern *regexp.Regexp
+	group   int
+}
+
+// NewPatternScheme compiles pattern, which must have a named capture group called "version".
+func NewPatternScheme(pattern string) (VersionScheme, error) {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("compiling milestone pattern %q: %+v", pattern, err)
+	}
+
+	group := r.SubexpIndex("version")
+	if group < 0 {
+		return nil, fmt.Errorf("milestone pattern %q must have a named capture group (?P<version>...)", pattern)
+	}
+	return patternScheme{r, group}, nil
+}
+
+func (s patternScheme) Match(title string) bool {
+	return s.pattern.MatchString(title)
+}
+
+// Compare orders the captured versions by semver precedence when both are semver, with or without a leading "v",
+// and otherwise by comparing their numeric parts in turn, e.g. 1.2 < 1.10 < 2.
+func (s patternScheme) Compare(a string, b string) int {
+	va, vb := s.version(a), s.version(b)
+
+	sa, sb := "v"+strings.TrimPrefix(va, "v"), "v"+strings.TrimPrefix(vb, "v")
+	if semver.IsValid(sa) && semver.IsValid(sb) {
+		return semver.Compare(sa, sb)
+	}
+
+	na, nb := numericParts.FindAllString(va, -1), numericParts.FindAllString(vb, -1)
+	for i := 0; i < len(na) && i < len(nb); i++ {
+		ia, _ := strconv.Atoi(na[i])
+		ib, _ := strconv.Atoi(nb[i])
+		if ia != ib {
+			if ia < ib {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(na) < len(nb):
+		return -1
+	case len(na) > len(nb):
+		return 1
+	}
+	return strings.Compare(va, vb)
+}
+
+func (patternScheme) Next(string, string) (string, error) {
+	return "", fmt.Errorf("creating milestones isn't supported with a custom milestone pattern")
+}
+
+func (s patternScheme) version(title string) string {
+	m := s.pattern.FindStringSubmatch(title)
+	if m == nil {
+		return ""
+	}
+	return m[s.group]
+}
+
+var numericParts = regexp.MustCompile(`[0-9]+`)
+
+// versionParts returns the numbers making up a version, e.g. 1, 2 and 5 for v1.2.5-rc1, leaving out any prerelease
+// or build. For a custom pattern they are taken from the captured version, or from version itself when it doesn't
+// match the pattern, e.g. a reference version given as v1.2.
+func versionParts(scheme VersionScheme, version string) []int {
+	if s, ok := scheme.(patternScheme); ok && s.Match(version) {
+		version = s.version(version)
+	}
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+
+	var parts []int
+	for _, p := range numericParts.FindAllString(version, -1) {
+		n, _ := strconv.Atoi(p)
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// closer reports whether version a is closer to reference than b, comparing the difference in their major versions
+// first, then in their minor versions and so on, so v1.3.0 is closer to v1.2.5 than v2.0.0 is.
+func closer(scheme VersionScheme, reference string, a string, b string) bool {
+	ref, pa, pb := versionParts(scheme, reference), versionParts(scheme, a), versionParts(scheme, b)
+	part := func(parts []int, i int) int {
+		if i < len(parts) {
+			return parts[i]
+		}
+		return 0
+	}
+	abs := func(n int) int {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+
+	for i := 0; i < len(ref) || i < len(pa) || i < len(pb); i++ {
+		da, db := abs(part(pa, i)-part(ref, i)), abs(part(pb, i)-part(ref, i))
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package linker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSemverSchemeMatch(t *testing.T) {
+	cases := []struct {
+		title    string
+		expected bool
+	}{
+		{"v0.1.0", true},
+		{"v10.0.0", true},
+		{"v1.2.15", true},
+		{"v2.0.0-rc1", true},
+		{"1.2.3", false},
+		{"v1.2", false},
+		{"Backlog", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			if match := (SemverScheme{}).Match(tc.title); match != tc.expected {
+				t.Errorf("expected match to be %t, got %t", tc.expected, match)
+			}
+		})
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	versions := []string{"v1.10.0", "v2.0.0", "v1.0.0", "v1.2.0"}
+	sortVersions(SemverScheme{}, versions)
+
+	expected := []string{"v1.0.0", "v1.2.0", "v1.10.0", "v2.0.0"}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Fatalf("expected %v, got %v", expected, versions)
+	}
+	if versions[0] != "v1.0.0" {
+		t.Errorf("expected the lowest version to be v1.0.0, got %s", versions[0])
+	}
+}
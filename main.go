@@ -3,153 +3,297 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"net/url"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/google/go-github/github"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
-	"golang.org/x/mod/semver"
 	"golang.org/x/oauth2"
+
+	"github.com/stephybun/link-milestone/linker"
 )
 
 // This script should only run when PRs are merged into main. It links the merged PR as well as linked issues
 // that were closed as a result of the merge, to the latest unreleased milestone (if exists and not already linked).
 
-type GitHubIssue struct {
-	Owner string
-	Repo string
-	Id int
-}
+const (
+	prereleaseInclude = "include"
+	prereleaseIgnore  = "ignore"
+)
 
-func (g GitHubIssue) getMilestoneId(ctx context.Context, client *github.Client) (*int, error) {
-	ghMilestones, _, err := client.Issues.ListMilestones(ctx, g.Owner, g.Repo, nil)
-	if err != nil {
-		return nil, fmt.Errorf("retrieving list of milestones: %+v", err)
+// modeCheck reports what a run would link to without a pull request or any changes, to check the setup.
+const modeCheck = "check"
+
+const (
+	outputFormatText = "text"
+	outputFormatJSON = "json"
+)
+
+// logger writes the run's log lines, with the format and level it is configured with by loadConfig.
+var logger linker.Logger
+
+// newGitHubClient returns a client for the public GitHub API, or for a GitHub Enterprise Server instance when baseURL
+// is set to its API endpoint, e.g. https://github.example.com/api/v3.
+func newGitHubClient(ctx context.Context, ts oauth2.TokenSource, baseURL string) (*github.Client, error) {
+	tc := oauth2.NewClient(ctx, ts)
+
+	if baseURL == "" {
+		return github.NewClient(tc), nil
 	}
 
-	milestones := make(map[string]int)
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid github api url %q: must be an absolute http(s) url", baseURL)
+	}
 
-	for _, m := range ghMilestones {
-		title := *m.Title
-		r := regexp.MustCompile(`v[0-9]\.[0-9]+\.0`)
-		if r.MatchString(title) && !strings.EqualFold(*m.State, "closed") {
-			milestones[title[1:]] = *m.Number
-		}
+	// GitHub Actions always sets GITHUB_API_URL, which points at the public API outside of Enterprise Server
+	if strings.EqualFold(u.Host, "api.github.com") {
+		return github.NewClient(tc), nil
 	}
 
-	// TODO create milestone here?
-	if len(milestones) == 0 {
-		return nil, fmt.Errorf("no open version milestones were found")
+	apiPath := strings.TrimSuffix(u.Path, "/")
+	u.Path = apiPath + "/"
+	uploadURL := *u
+	if strings.HasSuffix(apiPath, "/api/v3") {
+		uploadURL.Path = strings.TrimSuffix(apiPath, "/v3") + "/uploads/"
 	}
 
-	var versions []string
-	for title, _ := range milestones {
-		versions = append(versions, title)
+	client, err := github.NewEnterpriseClient(u.String(), uploadURL.String(), tc)
+	if err != nil {
+		return nil, fmt.Errorf("creating github enterprise client: %+v", err)
 	}
-	semver.Sort(versions)
-	milestoneId := milestones[versions[0]]
+	return client, nil
+}
 
-	log.Printf("[DEBUG] lowest open version milestone: %s", versions[0])
-	return &milestoneId, nil
+// RunSummary describes what a run did, for callers other than main that want to inspect it. When several pull
+// requests are linked, the milestone is the one used for the last of them and PRLinked is set if the milestone of any
+// was changed, or would have been in a dry run.
+type RunSummary struct {
+	MilestoneNumber int
+	MilestoneTitle  string
+	PRLinked        bool
+	LinkedIssues    []linker.GitHubIssue
+	DryRun          bool
 }
 
-func (g GitHubIssue) getLinkedIssue(ctx context.Context, client *github.Client) (*int, error) {
-	resp, _, _ := client.Issues.Get(ctx, g.Owner, g.Repo, g.Id)
-
-	if resp.Body != nil {
-		bodySplit := strings.Split(*resp.Body, " ")
-		keywords := regexp.MustCompile(`[fF]ix(e)?(s)?(d)?$|[cC]lose(s)?(d)?$|[rR]esolve(s)?(d)?$`)
-		issue := regexp.MustCompile(`^#[0-9]+`)
-		for i, s := range bodySplit {
-			if keywords.MatchString(s) {
-				// check whether next element is the issue number
-				next := bodySplit[i + 1]
-				if match := issue.FindString(next); match != "" {
-					id, _ := strconv.Atoi(match[1:])
-					return &id, nil
-				}
-			}
+func run() (summary *RunSummary, err error) {
+	// the metrics are written however the run ends, so that runs failing before linking anything, e.g. for a missing
+	// token, show up as errors too
+	var summaries []prSummary
+	var cfg *config
+	defer func() {
+		path := viper.GetString("metrics_file")
+		if cfg != nil {
+			path = cfg.MetricsPath
+		}
+		if merr := writeMetrics(path, summaries, err); merr != nil {
+			logger.Errorf(linker.LogFields{}, "%+v", merr)
 		}
+	}()
+
+	cfg, err = loadConfig()
+	if err != nil {
+		return nil, &exitError{exitConfig, err}
 	}
+	if len(cfg.PrIds) == 0 && cfg.Mode != modeCheck {
+		return nil, nil
+	}
+	summary = &RunSummary{DryRun: cfg.Update.DryRun}
 
-	log.Printf("[DEBUG] no special keywords found in issue description")
-	return nil, nil
-}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	ctx = linker.WithLogger(ctx, cfg.Logger)
+	if cfg.MaxRetries != nil {
+		ctx = linker.WithMaxRetries(ctx, *cfg.MaxRetries)
+	}
+	defer func() {
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = &exitError{exitGitHub, fmt.Errorf("timed out after %s: %+v", cfg.Timeout, err)}
+		}
+	}()
 
-func (g GitHubIssue) updateMilestone(ctx context.Context, client *github.Client, milestoneId int) error {
-	issue, _, err := client.Issues.Get(ctx, g.Owner, g.Repo, g.Id)
+	ts, err := newTokenSource(ctx, cfg.Token, cfg.App, cfg.BaseURL)
+	if err != nil {
+		return summary, &exitError{exitConfig, err}
+	}
+	client, err := newGitHubClient(ctx, ts, cfg.BaseURL)
 	if err != nil {
-		return fmt.Errorf("getting issue #%d: %+v", g.Id, err)
+		return summary, &exitError{exitConfig, err}
+	}
+	verified := false
+	if cfg.ValidateToken || cfg.Mode == modeCheck {
+		if verified, err = verifyToken(ctx, client); err != nil {
+			return summary, &exitError{exitGitHub, err}
+		}
 	}
 
-	if issue.Milestone == nil && strings.EqualFold(*issue.State, "closed") {
-		_, _, err := client.Issues.Edit(ctx, g.Owner, g.Repo, g.Id, &github.IssueRequest{Milestone: &milestoneId})
-		if err != nil {
-			return fmt.Errorf("updating milestone on issue #%d: %+v", g.Id, err)
+	l := linker.New(client, linker.Options{
+		Mode:             cfg.Mode,
+		Milestone:        cfg.Milestone,
+		Update:           cfg.Update,
+		SkipPR:           cfg.SkipPR,
+		LinkOnOpen:       cfg.LinkOnOpen,
+		CloseCompleted:   cfg.CloseCompleted,
+		Concurrency:      cfg.Concurrency,
+		LinkMode:         cfg.LinkMode,
+		Keywords:         cfg.Keywords,
+		KeywordGap:       cfg.KeywordGap,
+		ScanCommits:      cfg.ScanCommits,
+		LabelFallback:    cfg.LabelFallback,
+		TimelineFallback: cfg.TimelineFallback,
+		LinkRepos:        cfg.LinkRepos,
+		ProjectColumn:    cfg.ProjectColumn,
+		References:       cfg.References,
+		Comment:          cfg.Comment,
+		RequireLabel:     cfg.RequireLabel,
+		ExcludeAuthors:   cfg.ExcludeAuthors,
+		RequireMilestone: cfg.FailIfNoMilestone,
+		Logger:           cfg.Logger,
+		MaxRetries:       cfg.MaxRetries,
+	})
+
+	if cfg.Mode == modeCheck {
+		if err = check(ctx, l, cfg, verified); err != nil {
+			return summary, &exitError{exitGitHub, err}
 		}
-		return nil
+		return summary, nil
 	}
 
-	log.Printf("[DEBUG] github issue #%d already has milestone %s", g.Id, *issue.Milestone.Title)
-	return nil
-}
+	// a failing pull request doesn't stop the others from being linked, the failures are reported together at the end
+	var milestone *github.Milestone
+	var linkedIssues []linker.GitHubIssue
+	var failures []string
+	// the exit code for failed pull requests, exitNoMilestone only when that is why every one of them failed
+	code := exitNoMilestone
+	var completed []string
+	var interrupted error
+	for i, prId := range cfg.PrIds {
+		pr := linker.GitHubIssue{Owner: cfg.Owner, Repo: cfg.Repo, Id: prId}
+		result, err := l.Link(ctx, pr)
+		summaries = append(summaries, prSummary{pr, result, err})
+		if err != nil && ctx.Err() != nil && len(cfg.PrIds) > 1 {
+			// the run was cut short, so what was done is reported and only the rest needs to be run again
+			if len(completed) > 0 {
+				logger.Warnf(linker.LogFields{}, "linked pull requests %s before stopping", strings.Join(completed, ", "))
+			}
+			left := make([]string, len(cfg.PrIds)-i)
+			for j, id := range cfg.PrIds[i:] {
+				left[j] = strconv.Itoa(id)
+			}
+			interrupted = fmt.Errorf("stopped with %d of %d pull requests left to link, PR_NUMBERS=%s: %+v", len(left), len(cfg.PrIds), strings.Join(left, ","), err)
+			break
+		}
+		if err != nil && len(cfg.PrIds) == 1 {
+			if serr := writeSummary(cfg.SummaryPath, cfg.Mode, cfg.Update.DryRun, summaries); serr != nil {
+				logger.Errorf(linker.LogFields{}, "%+v", serr)
+			}
+			return summary, &exitError{linkExitCode(err), err}
+		}
+		if err != nil {
+			if code != exitGitHub {
+				code = linkExitCode(err)
+			}
+			logger.Errorf(linker.LogFields{Issue: pr.String()}, "linking pull request #%d: %+v", prId, err)
+			failures = append(failures, fmt.Sprintf("#%d: %+v", prId, err))
+			continue
+		}
+		if result != nil {
+			milestone = result.Milestone
+			linkedIssues = append(linkedIssues, result.LinkedIssues...)
+			for _, c := range result.Changes {
+				if c.Issue == pr {
+					summary.PRLinked = true
+				}
+			}
+		}
+		completed = append(completed, fmt.Sprintf("#%d", prId))
+	}
 
-func newGitHubClient(token string) (*github.Client, context.Context) {
-	ctx := context.Background()
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-	return github.NewClient(tc), ctx
-}
+	summary.LinkedIssues = linkedIssues
+	if milestone != nil {
+		summary.MilestoneNumber = milestone.GetNumber()
+		summary.MilestoneTitle = milestone.GetTitle()
+		err = writeOutputs(cfg.OutputPath, [][2]string{
+			{"milestone_number", strconv.Itoa(milestone.GetNumber())},
+			{"milestone_title", milestone.GetTitle()},
+			{"linked_issues", joinIssues(linkedIssues, cfg.Owner, cfg.Repo)},
+		})
+		if err != nil {
+			return summary, err
+		}
+	}
 
-func run() error {
-	viper.AutomaticEnv()
-	token := viper.GetString("github_token")
-	owner := strings.Split(viper.GetString("github_repository"), "/")[0]
-	repo := strings.Split(viper.GetString("github_repository"), "/")[1]
-	prId, err := strconv.Atoi(viper.GetString("pr_number"))
-	if err != nil {
-		return fmt.Errorf("parsing pr number: %+v", err)
+	if cfg.Update.DryRun && cfg.OutputFormat == outputFormatJSON {
+		if err = writeDiff(os.Stdout, cfg.OutputPath, summaries); err != nil {
+			return summary, err
+		}
 	}
 
-	pr := GitHubIssue{owner, repo, prId}
-	client, ctx := newGitHubClient(token)
+	if err = writeSummary(cfg.SummaryPath, cfg.Mode, cfg.Update.DryRun, summaries); err != nil {
+		return summary, err
+	}
 
-	milestoneId, err := pr.getMilestoneId(ctx, client)
-	if err != nil {
-		return fmt.Errorf("getting milestone id: %s", err)
+	if interrupted != nil {
+		return summary, &exitError{exitGitHub, interrupted}
 	}
-	if milestoneId == nil {
-		log.Printf("[DEBUG] no open version milestones exists in github")
-		return nil
+
+	if len(failures) > 0 {
+		err = fmt.Errorf("linking %d of %d pull requests failed: %s", len(failures), len(cfg.PrIds), strings.Join(failures, "; "))
+		return summary, &exitError{code, err}
 	}
 
-	if err = pr.updateMilestone(ctx, client, *milestoneId); err != nil {
-		return err
+	return summary, nil
+}
+
+// linkExitCode returns the code to exit with when linking a pull request failed with err.
+func linkExitCode(err error) int {
+	if err == linker.ErrNoMilestone {
+		return exitNoMilestone
 	}
+	return exitGitHub
+}
 
-	liId, err := pr.getLinkedIssue(ctx, client)
+// check prints the open version milestones of the repository and the one a run would link to, along with whether
+// the token was verified.
+func check(ctx context.Context, l *linker.Linker, cfg *config, verified bool) error {
+	result, err := l.Check(ctx, cfg.Owner, cfg.Repo)
 	if err != nil {
-		return fmt.Errorf("getting linked issues for #%d: %+v", pr.Id, err)
+		return err
 	}
 
-	if liId != nil {
-		li := GitHubIssue{owner, repo, *liId}
-		if err = li.updateMilestone(ctx, client, *milestoneId); err != nil {
-			return err
-		}
+	if verified {
+		fmt.Printf("token: ok\n")
+	} else {
+		fmt.Printf("token: unverified\n")
+	}
+	fmt.Printf("repository: %s/%s\n", cfg.Owner, cfg.Repo)
+	fmt.Printf("open version milestones: %d\n", len(result.Milestones))
+	for _, m := range result.Milestones {
+		fmt.Printf("  - %s (%d)\n", m.GetTitle(), m.GetNumber())
+	}
+	if result.Selected == nil {
+		fmt.Printf("selected milestone: none\n")
+	} else {
+		fmt.Printf("selected milestone: %s (%d)\n", result.Selected.GetTitle(), result.Selected.GetNumber())
 	}
-
 	return nil
 }
 
 func main() {
-	if err := run(); err != nil {
-		log.Fatal(err)
+	if err := parseFlags(os.Args[1:]); err != nil {
+		if err == pflag.ErrHelp || err == errVersion {
+			os.Exit(0)
+		}
+		logger.Errorf(linker.LogFields{}, "%+v", err)
+		os.Exit(exitConfig)
+	}
+
+	if _, err := run(); err != nil {
+		logger.Errorf(linker.LogFields{}, "%+v", err)
+		os.Exit(exitCode(err))
 	}
-	os.Exit(0)
-}
\ No newline at end of file
+	os.Exit(exitOK)
+}
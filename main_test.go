@@ -0,0 +1,779 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/spf13/viper"
+)
+
+// fakeGitHub is a test server for the part of the GitHub REST API a run uses, holding the issues, pull requests and
+// milestones of owner/repo.
+type fakeGitHub struct {
+	mu sync.Mutex
+
+	issues     map[int]*github.Issue
+	merged     map[int]bool
+	milestones []*github.Milestone
+	// failPulls answers requests for these pull requests with a server error.
+	failPulls map[int]bool
+	// blockPulls leaves requests for these pull requests unanswered until the client gives up on them.
+	blockPulls map[int]bool
+	// userStatus, when set, is the status requests for the authenticated user fail with.
+	userStatus int
+
+	// edits are the milestone numbers set on each issue, zero when one was removed.
+	edits   map[int]int
+	created []string
+	// auth is the Authorization header of the last request.
+	auth string
+	// writes are the requests other than GETs, e.g. "PATCH /repos/owner/repo/issues/10".
+	writes []string
+}
+
+var (
+	pullPath      = regexp.MustCompile(`^/repos/owner/repo/pulls/([0-9]+)$`)
+	issuePath     = regexp.MustCompile(`^/repos/owner/repo/issues/([0-9]+)$`)
+	milestonePath = regexp.MustCompile(`^/repos/owner/repo/milestones/([0-9]+)$`)
+)
+
+// newFakeGitHub starts a fakeGitHub and points a run at it through GITHUB_BASE_URL, with the repository and token set.
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	f := &fakeGitHub{
+		issues:     make(map[int]*github.Issue),
+		merged:     make(map[int]bool),
+		failPulls:  make(map[int]bool),
+		blockPulls: make(map[int]bool),
+		edits:      make(map[int]int),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+
+	resetConfig(t)
+	setenv(t, "GITHUB_BASE_URL", srv.URL+"/")
+	setenv(t, "GITHUB_REPOSITORY", "owner/repo")
+	setenv(t, "GITHUB_TOKEN", "token")
+	return f
+}
+
+// resetConfig clears the settings read by earlier runs, and the run's files in the environment of the test process,
+// until the end of the test.
+func resetConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	for _, name := range []string{"GITHUB_OUTPUT", "GITHUB_STEP_SUMMARY", "GITHUB_EVENT_PATH", "GITHUB_API_URL"} {
+		setenv(t, name, "")
+	}
+	setenv(t, "GITHUB_WORKSPACE", t.TempDir())
+}
+
+// setenv sets the environment variable name to value until the end of the test.
+func setenv(t *testing.T, name string, value string) {
+	old, ok := os.LookupEnv(name)
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func (f *fakeGitHub) addMilestone(number int, title string, state string) {
+	f.milestones = append(f.milestones, &github.Milestone{Number: github.Int(number), Title: github.String(title), State: github.String(state)})
+}
+
+func (f *fakeGitHub) addIssue(number int, state string, body string) *github.Issue {
+	issue := &github.Issue{Number: github.Int(number), State: github.String(state), Title: github.String(fmt.Sprintf("issue %d", number)), Body: github.String(body)}
+	f.issues[number] = issue
+	return issue
+}
+
+// addPR adds a pull request, which is an issue too.
+func (f *fakeGitHub) addPR(number int, merged bool, body string) {
+	f.addIssue(number, "closed", body).PullRequestLinks = &github.PullRequestLinks{}
+	f.merged[number] = merged
+}
+
+func (f *fakeGitHub) milestone(number int) *github.Milestone {
+	for _, m := range f.milestones {
+		if m.GetNumber() == number {
+			return m
+		}
+	}
+	return nil
+}
+
+func (f *fakeGitHub) serve(w http.ResponseWriter, r *http.Request) {
+	if m := pullPath.FindStringSubmatch(r.URL.Path); m != nil {
+		if n, _ := strconv.Atoi(m[1]); f.blockPulls[n] {
+			<-r.Context().Done()
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = r.Header.Get("Authorization")
+	if r.Method != "GET" {
+		f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+	}
+
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	fail := func(code int) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"message": %q}`, http.StatusText(code))
+	}
+	number := func(m []string) int {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+
+	switch {
+	case r.Method == "GET" && pullPath.MatchString(r.URL.Path):
+		n := number(pullPath.FindStringSubmatch(r.URL.Path))
+		issue, ok := f.issues[n]
+		if !ok || !issue.IsPullRequest() {
+			fail(http.StatusNotFound)
+			return
+		}
+		if f.failPulls[n] {
+			fail(http.StatusInternalServerError)
+			return
+		}
+		reply(map[string]interface{}{"number": n, "merged": f.merged[n], "state": issue.GetState(), "title": issue.GetTitle(), "user": issue.GetUser(), "base": map[string]string{"ref": "main"}})
+
+	case r.Method == "GET" && issuePath.MatchString(r.URL.Path):
+		issue, ok := f.issues[number(issuePath.FindStringSubmatch(r.URL.Path))]
+		if !ok {
+			fail(http.StatusNotFound)
+			return
+		}
+		reply(issue)
+
+	case r.Method == "PATCH" && issuePath.MatchString(r.URL.Path):
+		n := number(issuePath.FindStringSubmatch(r.URL.Path))
+		var req struct {
+			Milestone *int `json:"milestone"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		issue := f.issues[n]
+		issue.Milestone = nil
+		f.edits[n] = 0
+		if req.Milestone != nil {
+			issue.Milestone = f.milestone(*req.Milestone)
+			f.edits[n] = *req.Milestone
+		}
+		reply(issue)
+
+	case r.Method == "GET" && r.URL.Path == "/repos/owner/repo/milestones":
+		state := r.URL.Query().Get("state")
+		listed := []*github.Milestone{}
+		for _, m := range f.milestones {
+			if state == "all" || m.GetState() == state {
+				listed = append(listed, m)
+			}
+		}
+		reply(listed)
+
+	case r.Method == "POST" && r.URL.Path == "/repos/owner/repo/milestones":
+		var m github.Milestone
+		json.NewDecoder(r.Body).Decode(&m)
+		f.addMilestone(len(f.milestones)+100, m.GetTitle(), "open")
+		f.created = append(f.created, m.GetTitle())
+		reply(f.milestones[len(f.milestones)-1])
+
+	case r.Method == "GET" && milestonePath.MatchString(r.URL.Path):
+		m := f.milestone(number(milestonePath.FindStringSubmatch(r.URL.Path)))
+		if m == nil {
+			fail(http.StatusNotFound)
+			return
+		}
+		reply(m)
+
+	case r.Method == "GET" && r.URL.Path == "/user":
+		if f.userStatus != 0 {
+			fail(f.userStatus)
+			return
+		}
+		reply(map[string]string{"login": "octocat"})
+
+	default:
+		fail(http.StatusNotFound)
+	}
+}
+
+// editedIssues returns the numbers of the issues whose milestone was changed, in order.
+func (f *fakeGitHub) editedIssues() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var edited []int
+	for n := range f.edits {
+		edited = append(edited, n)
+	}
+	sort.Ints(edited)
+	return edited
+}
+
+func TestRunLinksPullRequestAndIssues(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.addMilestone(1, "v1.0.0", "closed")
+	gh.addMilestone(2, "v1.2.0", "open")
+	gh.addMilestone(3, "v1.1.0", "open")
+	gh.addPR(10, true, "Fixes #11 and #12")
+	gh.addIssue(11, "closed", "")
+	gh.addIssue(12, "open", "")
+	setenv(t, "PR_NUMBER", "10")
+
+	summary, err := run()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if summary.MilestoneTitle != "v1.1.0" {
+		t.Errorf("expected the lowest open milestone v1.1.0 to be selected, got %q", summary.MilestoneTitle)
+	}
+	if edited := gh.editedIssues(); len(edited) != 2 || edited[0] != 10 || edited[1] != 11 {
+		t.Errorf("expected the pull request and the closed issue to be linked, got %v", edited)
+	}
+	if gh.edits[10] != 3 || gh.edits[11] != 3 {
+		t.Errorf("expected milestone 3 to be set, got %v", gh.edits)
+	}
+}
+
+func TestRunWithoutMilestone(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.addMilestone(1, "v1.0.0", "closed")
+	gh.addMilestone(2, "Backlog", "open")
+	gh.addPR(10, true, "Fixes #11")
+	gh.addIssue(11, "closed", "")
+	setenv(t, "PR_NUMBER", "10")
+
+	summary, err := run()
+	if err != nil {
+		t.Fatalf("expected a run without a milestone to succeed, got %+v", err)
+	}
+	if summary.MilestoneTitle != "" || summary.PRLinked {
+		t.Errorf("expected nothing to be linked, got %+v", summary)
+	}
+	if edited := gh.editedIssues(); len(edited) > 0 {
+		t.Errorf("expected nothing to be edited, got %v", edited)
+	}
+	if code := exitCode(err); code != exitOK {
+		t.Errorf("expected exit code %d, got %d", exitOK, code)
+	}
+}
+
+func TestRunFailIfNoMilestone(t *testing.T) {
+	for _, fail := range []string{"false", "true"} {
+		t.Run(fail, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.addMilestone(2, "Backlog", "open")
+			gh.addPR(10, true, "Fixes #11")
+			gh.addIssue(11, "closed", "")
+			setenv(t, "PR_NUMBER", "10")
+			setenv(t, "FAIL_IF_NO_MILESTONE", fail)
+
+			_, err := run()
+			expected := exitOK
+			if fail == "true" {
+				expected = exitNoMilestone
+				if err == nil || !strings.Contains(err.Error(), "no open version milestone") {
+					t.Errorf("expected the missing milestone to be reported, got %+v", err)
+				}
+			}
+			if code := exitCode(err); code != expected {
+				t.Errorf("expected exit code %d, got %d", expected, code)
+			}
+			if edited := gh.editedIssues(); len(edited) > 0 {
+				t.Errorf("expected nothing to be edited, got %v", edited)
+			}
+		})
+	}
+}
+
+func TestRunLinksRemainingPullRequestsAfterFailure(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.addMilestone(2, "v1.1.0", "open")
+	for _, n := range []int{10, 20, 30} {
+		gh.addPR(n, true, "")
+	}
+	gh.failPulls[20] = true
+	setenv(t, "PR_NUMBERS", "10,20,30")
+
+	_, err := run()
+	if err == nil {
+		t.Fatalf("expected the failing pull request to be reported")
+	}
+	if code := exitCode(err); code != exitGitHub {
+		t.Errorf("expected exit code %d, got %d", exitGitHub, code)
+	}
+	if edited := gh.editedIssues(); len(edited) != 2 || edited[0] != 10 || edited[1] != 30 {
+		t.Errorf("expected the other pull requests to be linked, got %v", edited)
+	}
+}
+
+func TestRunWithoutToken(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.addPR(10, true, "")
+	setenv(t, "PR_NUMBER", "10")
+	setenv(t, "GITHUB_TOKEN", "")
+
+	_, err := run()
+	if err == nil || !strings.Contains(err.Error(), "github token must be set") {
+		t.Fatalf("expected a missing token to be reported, got %+v", err)
+	}
+	if code := exitCode(err); code != exitConfig {
+		t.Errorf("expected exit code %d, got %d", exitConfig, code)
+	}
+	if edited := gh.editedIssues(); len(edited) > 0 {
+		t.Errorf("expected nothing to be edited, got %v", edited)
+	}
+}
+
+func TestRunReadsConfigFile(t *testing.T) {
+	cases := []struct {
+		name     string
+		file     string
+		expected string
+	}{
+		{"no file", "", "v1.1.0"},
+		{"selection", "milestone_selection: highest\n", "v1.2.0"},
+		{"flag name", "selection: highest\n", "v1.2.0"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.addMilestone(2, "v1.2.0", "open")
+			gh.addMilestone(3, "v1.1.0", "open")
+			gh.addPR(10, true, "")
+			setenv(t, "PR_NUMBER", "10")
+			if tc.file != "" {
+				path := filepath.Join(os.Getenv("GITHUB_WORKSPACE"), configFileName)
+				if err := ioutil.WriteFile(path, []byte(tc.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			summary, err := run()
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if summary.MilestoneTitle != tc.expected {
+				t.Errorf("expected milestone %s, got %q", tc.expected, summary.MilestoneTitle)
+			}
+		})
+	}
+}
+
+func TestRunReportsProgressWhenTimedOut(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.addMilestone(2, "v1.1.0", "open")
+	for _, n := range []int{10, 20, 30} {
+		gh.addPR(n, true, "")
+	}
+	gh.blockPulls[20] = true
+	setenv(t, "PR_NUMBERS", "10,20,30")
+	setenv(t, "REQUEST_TIMEOUT", "500ms")
+
+	_, err := run()
+	if err == nil || !strings.Contains(err.Error(), "stopped with 2 of 3 pull requests left to link, PR_NUMBERS=20,30") {
+		t.Fatalf("expected the pull requests left to be reported, got %+v", err)
+	}
+	if code := exitCode(err); code != exitGitHub {
+		t.Errorf("expected exit code %d, got %d", exitGitHub, code)
+	}
+	if edited := gh.editedIssues(); len(edited) != 1 || edited[0] != 10 {
+		t.Errorf("expected only the first pull request to be linked, got %v", edited)
+	}
+}
+
+func TestRunSkipsListedRepository(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.addMilestone(2, "v1.1.0", "open")
+	gh.addPR(10, true, "")
+	setenv(t, "PR_NUMBER", "10")
+	setenv(t, "SKIP_REPOS", "other/project, Owner/Repo")
+
+	if _, err := run(); err != nil {
+		t.Fatalf("expected the repository to be skipped, got %+v", err)
+	}
+	if edited := gh.editedIssues(); len(edited) > 0 {
+		t.Errorf("expected nothing to be edited, got %v", edited)
+	}
+}
+
+func TestRunReadsTokenFile(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.addMilestone(2, "v1.1.0", "open")
+	gh.addPR(10, true, "")
+	setenv(t, "PR_NUMBER", "10")
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(path, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "GITHUB_TOKEN", "")
+	setenv(t, "GITHUB_TOKEN_FILE", path)
+
+	if _, err := run(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if gh.auth != "Bearer secret" {
+		t.Errorf("expected the token from the file to be used, got %q", gh.auth)
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	cases := []struct {
+		name      string
+		milestone int
+		dryRun    bool
+		expected  RunSummary
+	}{
+		{
+			name:     "linked",
+			expected: RunSummary{MilestoneNumber: 2, MilestoneTitle: "v1.1.0", PRLinked: true},
+		},
+		{
+			name:      "already linked",
+			milestone: 2,
+			expected:  RunSummary{MilestoneNumber: 2, MilestoneTitle: "v1.1.0"},
+		},
+		{
+			name:     "dry run",
+			dryRun:   true,
+			expected: RunSummary{MilestoneNumber: 2, MilestoneTitle: "v1.1.0", PRLinked: true, DryRun: true},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.addMilestone(2, "v1.1.0", "open")
+			gh.addPR(10, true, "")
+			gh.issues[10].Milestone = gh.milestone(tc.milestone)
+			setenv(t, "PR_NUMBER", "10")
+			setenv(t, "DRY_RUN", strconv.FormatBool(tc.dryRun))
+
+			summary, err := run()
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if !reflect.DeepEqual(*summary, tc.expected) {
+				t.Errorf("expected summary %+v, got %+v", tc.expected, *summary)
+			}
+		})
+	}
+}
+
+func TestRunSelectsVersionFileMilestone(t *testing.T) {
+	cases := []struct {
+		name     string
+		file     string
+		expected string
+	}{
+		{"version in file", "version: 1.4.0\n", "v1.4.0"},
+		{"no file", "", "v1.3.0"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.addMilestone(2, "v1.3.0", "open")
+			gh.addMilestone(3, "v1.4.0", "open")
+			gh.addPR(10, true, "")
+			setenv(t, "PR_NUMBER", "10")
+			setenv(t, "VERSION_FILE", "VERSION")
+			if tc.file != "" {
+				path := filepath.Join(os.Getenv("GITHUB_WORKSPACE"), "VERSION")
+				if err := ioutil.WriteFile(path, []byte(tc.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			summary, err := run()
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if summary.MilestoneTitle != tc.expected {
+				t.Errorf("expected milestone %s, got %q", tc.expected, summary.MilestoneTitle)
+			}
+		})
+	}
+}
+
+func TestRunMilestonePatternOverride(t *testing.T) {
+	cases := []struct {
+		name     string
+		file     string
+		env      string
+		expected string
+	}{
+		{"organisation default", "", "", "Release 2.0"},
+		{"config file", "milestone_pattern: '^Train (?P<version>[0-9.]+)$'\n", "", "Train 1.0"},
+		{"environment", "milestone_pattern: '^Train (?P<version>[0-9.]+)$'\n", "^Sprint (?P<version>[0-9]+)$", "Sprint 7"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.addMilestone(2, "Release 2.0", "open")
+			gh.addMilestone(3, "Train 1.0", "open")
+			gh.addMilestone(4, "Sprint 7", "open")
+			gh.addPR(10, true, "")
+			setenv(t, "PR_NUMBER", "10")
+			setenv(t, "ORG_MILESTONE_PATTERN", "^Release (?P<version>[0-9.]+)$")
+			setenv(t, "MILESTONE_PATTERN", tc.env)
+			if tc.file != "" {
+				path := filepath.Join(os.Getenv("GITHUB_WORKSPACE"), configFileName)
+				if err := ioutil.WriteFile(path, []byte(tc.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			summary, err := run()
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if summary.MilestoneTitle != tc.expected {
+				t.Errorf("expected milestone %s, got %q", tc.expected, summary.MilestoneTitle)
+			}
+		})
+	}
+}
+
+func TestRunCheckMakesNoChanges(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.addMilestone(1, "v1.0.0", "closed")
+	gh.addMilestone(2, "v1.2.0", "open")
+	gh.addMilestone(3, "v1.1.0", "open")
+	gh.addPR(10, true, "Fixes #11")
+	gh.addIssue(11, "closed", "")
+	setenv(t, "PR_NUMBER", "10")
+	setenv(t, "MODE", "check")
+	setenv(t, "CREATE_MILESTONE", "true")
+
+	stdout, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = stdout
+	_, err = run()
+	os.Stdout = old
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if len(gh.writes) > 0 {
+		t.Errorf("expected no changes, got requests %v", gh.writes)
+	}
+	printed, err := ioutil.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"token: ok", "open version milestones: 2", "  - v1.1.0 (3)", "  - v1.2.0 (2)", "selected milestone: v1.1.0 (3)"} {
+		if !strings.Contains(string(printed), line+"\n") {
+			t.Errorf("expected %q to be printed, got %q", line, printed)
+		}
+	}
+}
+
+func TestRunCheckReportsToken(t *testing.T) {
+	cases := []struct {
+		status   int
+		expected string
+	}{
+		{0, "token: ok"},
+		{http.StatusForbidden, "token: ok"},
+		{http.StatusBadGateway, "token: unverified"},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("status %d", tc.status), func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.addMilestone(1, "v1.0.0", "open")
+			gh.userStatus = tc.status
+			setenv(t, "MODE", "check")
+			setenv(t, "MAX_RETRIES", "0")
+
+			stdout, err := ioutil.TempFile(t.TempDir(), "stdout")
+			if err != nil {
+				t.Fatal(err)
+			}
+			old := os.Stdout
+			os.Stdout = stdout
+			_, err = run()
+			os.Stdout = old
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			printed, err := ioutil.ReadFile(stdout.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(printed), tc.expected+"\n") {
+				t.Errorf("expected %q to be printed, got %q", tc.expected, printed)
+			}
+		})
+	}
+}
+
+func TestRunCheckFailsForRejectedToken(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.userStatus = http.StatusUnauthorized
+	setenv(t, "MODE", "check")
+
+	if _, err := run(); err == nil || exitCode(err) != exitGitHub {
+		t.Errorf("expected the run to fail with exit code %d, got %+v", exitGitHub, err)
+	}
+}
+
+func TestRunWritesMetrics(t *testing.T) {
+	cases := []struct {
+		name     string
+		token    string
+		expected []string
+	}{
+		{
+			name:  "linked",
+			token: "token",
+			expected: []string{
+				"# TYPE link_milestone_prs_processed gauge\nlink_milestone_prs_processed 1\n",
+				"# TYPE link_milestone_issues_linked gauge\nlink_milestone_issues_linked 1\n",
+				"# TYPE link_milestone_milestones_created gauge\nlink_milestone_milestones_created 0\n",
+				"# TYPE link_milestone_errors gauge\nlink_milestone_errors 0\n",
+			},
+		},
+		{
+			name: "without token",
+			expected: []string{
+				"# TYPE link_milestone_prs_processed gauge\nlink_milestone_prs_processed 0\n",
+				"# TYPE link_milestone_errors gauge\nlink_milestone_errors 1\n",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.addMilestone(2, "v1.1.0", "open")
+			gh.addPR(10, true, "Fixes #11")
+			gh.addIssue(11, "closed", "")
+			setenv(t, "PR_NUMBER", "10")
+			setenv(t, "GITHUB_TOKEN", tc.token)
+			path := filepath.Join(t.TempDir(), "link-milestone.prom")
+			setenv(t, "METRICS_FILE", path)
+
+			if _, err := run(); (err != nil) != (tc.token == "") {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			metrics, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("expected the metrics to be written: %+v", err)
+			}
+			for _, m := range tc.expected {
+				if !strings.Contains(string(metrics), m) {
+					t.Errorf("expected the metrics to contain %q, got %q", m, metrics)
+				}
+			}
+		})
+	}
+}
+
+func TestRunSkipsExcludedAuthor(t *testing.T) {
+	for _, author := range []string{"octocat", "dependabot[bot]"} {
+		t.Run(author, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.addMilestone(2, "v1.1.0", "open")
+			gh.addPR(10, true, "Fixes #11")
+			gh.issues[10].User = &github.User{Login: github.String(author)}
+			gh.addIssue(11, "closed", "")
+			setenv(t, "PR_NUMBER", "10")
+			setenv(t, "EXCLUDE_AUTHORS", "renovate[bot], Dependabot[bot]")
+
+			_, err := run()
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			edited := gh.editedIssues()
+			if author == "octocat" && len(edited) != 2 {
+				t.Errorf("expected the pull request and issue to be linked, got %v", edited)
+			}
+			if author != "octocat" && len(edited) > 0 {
+				t.Errorf("expected the pull request by %s to be skipped, got edits %v", author, edited)
+			}
+		})
+	}
+}
+
+func TestRunEventPullRequest(t *testing.T) {
+	cases := []struct {
+		name     string
+		mode     string
+		merged   bool
+		state    string
+		expected []int
+	}{
+		{name: "merged", mode: "link", merged: true, state: "closed", expected: []int{10, 11}},
+		{name: "closed without merging", mode: "link", state: "closed"},
+		{name: "open", mode: "link", state: "open"},
+		{name: "unlink reopened", mode: "unlink", state: "open", expected: []int{10, 11}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			gh.addMilestone(2, "v1.1.0", "open")
+			gh.addPR(10, tc.merged, "Fixes #11")
+			gh.issues[10].State = github.String(tc.state)
+			gh.addIssue(11, "closed", "")
+			if tc.mode == "unlink" {
+				gh.issues[10].Milestone = gh.milestone(2)
+				gh.issues[11].Milestone = gh.milestone(2)
+			}
+			setenv(t, "MODE", tc.mode)
+
+			path := filepath.Join(t.TempDir(), "event.json")
+			event := fmt.Sprintf(`{"pull_request": {"number": 10, "merged": %t, "state": %q}}`, tc.merged, tc.state)
+			if err := ioutil.WriteFile(path, []byte(event), 0644); err != nil {
+				t.Fatal(err)
+			}
+			setenv(t, "GITHUB_EVENT_PATH", path)
+
+			if _, err := run(); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if edited := gh.editedIssues(); !reflect.DeepEqual(edited, tc.expected) {
+				t.Errorf("expected issues %v to be edited, got %v", tc.expected, edited)
+			}
+			if tc.mode == "unlink" && (gh.edits[10] != 0 || gh.edits[11] != 0) {
+				t.Errorf("expected the milestone to be removed, got %v", gh.edits)
+			}
+		})
+	}
+}